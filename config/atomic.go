@@ -0,0 +1,65 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temp file in the same directory and renames it over
+// filePath, so readers never see a partially written file and a crash leaves the old one
+func writeFileAtomic(filePath string, data []byte) (err error) {
+	dir := filepath.Dir(filePath)
+
+	// the leading dot and random suffix keep the agent from picking up the temp file as a check
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filePath)+".")
+	if err != nil {
+		return err
+	}
+
+	// clean up the temp file unless it was renamed into place
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	// keep mode and ownership of the existing file, TempFile creates files as 0600
+	if info, statErr := os.Stat(filePath); statErr == nil {
+		if err = tmp.Chmod(info.Mode()); err != nil {
+			return err
+		}
+
+		// best effort, only root may give a file away (EPERM otherwise)
+		if chownErr := chown(tmp, info); chownErr != nil {
+			logger.Warnf("Could not keep the ownership of %s: %s", filePath, chownErr)
+		}
+	} else if err = tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+
+	// make the rename itself durable, best effort as not all platforms support it
+	if d, dirErr := os.Open(dir); dirErr == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing, jittered delays between retries
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt uint
+}
+
+// Next returns how long to wait before the next attempt
+func (b *Backoff) Next() time.Duration {
+	delay := b.Min << b.attempt
+	if delay <= 0 || delay > b.Max {
+		delay = b.Max
+	} else {
+		b.attempt++
+	}
+
+	// full jitter between half and the whole delay, so agents don't retry in lockstep
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Reset starts over from the minimum delay after a successful attempt
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -2,8 +2,13 @@ package config
 
 // ServiceEnabled ...
 func ServiceEnabled(suffix string, list []string) bool {
+	return HasTag("dd-"+suffix, list)
+}
+
+// HasTag returns true if the tag is in the list
+func HasTag(tag string, list []string) bool {
 	for _, b := range list {
-		if b == "dd-"+suffix {
+		if b == tag {
 			return true
 		}
 	}
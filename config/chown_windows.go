@@ -0,0 +1,8 @@
+package config
+
+import "os"
+
+// chown is a noop on windows, where files don't have unix ownership
+func chown(file *os.File, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Params are per-service check parameters, read from the Consul service Meta
+// ("dd-tcp-check-timeout" = "10") and tags ("dd-redisdb:db=2")
+type Params struct {
+	values map[string]string
+	used   map[string]bool
+}
+
+// ServiceParams collects the parameters for the backend enabled by tag,
+// tags win over Meta when both set the same parameter
+func ServiceParams(tag string, service *consul.AgentService) *Params {
+	p := &Params{
+		values: make(map[string]string),
+		used:   make(map[string]bool),
+	}
+
+	for key, value := range service.Meta {
+		if strings.HasPrefix(key, tag+"-") {
+			p.values[normalizeParam(strings.TrimPrefix(key, tag+"-"))] = value
+		}
+	}
+
+	for _, t := range service.Tags {
+		if !strings.HasPrefix(t, tag+":") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(t, tag+":"), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		p.values[normalizeParam(parts[0])] = parts[1]
+	}
+
+	return p
+}
+
+// normalizeParam lets "collect-response-time" and "collect_response_time" mean the same thing
+func normalizeParam(key string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(key)), "-", "_", -1)
+}
+
+// String returns the parameter, or def if it isn't set
+func (p *Params) String(key, def string) string {
+	p.used[key] = true
+
+	if value, found := p.values[key]; found {
+		return value
+	}
+
+	return def
+}
+
+// Int returns the parameter as an integer, or def if it isn't set
+func (p *Params) Int(key string, def int) (int, error) {
+	p.used[key] = true
+
+	value, found := p.values[key]
+	if !found {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for parameter %s: %q is not an integer", key, value)
+	}
+
+	return i, nil
+}
+
+// Bool returns the parameter as a boolean, or def if it isn't set
+func (p *Params) Bool(key string, def bool) (bool, error) {
+	p.used[key] = true
+
+	value, found := p.values[key]
+	if !found {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value for parameter %s: %q is not a boolean", key, value)
+	}
+
+	return b, nil
+}
+
+// List returns the parameter split on commas, or nil if it isn't set
+func (p *Params) List(key string) []string {
+	p.used[key] = true
+
+	value, found := p.values[key]
+	if !found || value == "" {
+		return nil
+	}
+
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// Values returns a copy of all parameters, e.g. for use in templates
+func (p *Params) Values() map[string]string {
+	values := make(map[string]string)
+	for key, value := range p.values {
+		p.used[key] = true
+		values[key] = value
+	}
+
+	return values
+}
+
+// Prefixed returns the parameters starting with prefix, with the prefix removed
+func (p *Params) Prefixed(prefix string) map[string]string {
+	values := make(map[string]string)
+	for key, value := range p.values {
+		if strings.HasPrefix(key, prefix) {
+			p.used[key] = true
+			values[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+
+	return values
+}
+
+// Unused returns the parameters that were set but never read by the backend,
+// usually a typo in the Nomad job spec
+func (p *Params) Unused() []string {
+	unused := make([]string, 0)
+	for key := range p.values {
+		if !p.used[key] {
+			unused = append(unused, key)
+		}
+	}
+
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+func TestServiceParams(t *testing.T) {
+	service := &consul.AgentService{
+		Meta: map[string]string{
+			"dd-redisdb-db":       "1",
+			"dd-redisdb-Timeout":  "10",
+			"dd-redisdb-password": "from-meta",
+			"dd-tcp-check-db":     "3",
+			"team":                "core",
+		},
+		Tags: []string{
+			"dd-redisdb",
+			"dd-redisdb:db=2",
+			"dd-redisdb:Collect-Response-Time=false",
+			"dd-redisdb:keys=a,b=c",
+			"dd-redisdb:invalid",
+			"dd-redisdb-other:db=4",
+		},
+	}
+
+	params := ServiceParams("dd-redisdb", service)
+
+	want := map[string]string{
+		"db":                    "2",
+		"timeout":               "10",
+		"password":              "from-meta",
+		"collect_response_time": "false",
+		"keys":                  "a,b=c",
+	}
+
+	if got := params.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Values() = %v, want %v", got, want)
+	}
+}
+
+func TestParamsTypes(t *testing.T) {
+	service := &consul.AgentService{
+		Tags: []string{
+			"dd-tcp-check:timeout=10",
+			"dd-tcp-check:port=http",
+			"dd-tcp-check:collect_response_time=false",
+			"dd-tcp-check:ssl=maybe",
+			"dd-tcp-check:keys= a, ,b ",
+			"dd-tcp-check:empty=",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		read  func(p *Params) (interface{}, error)
+		value interface{}
+		err   bool
+	}{
+		{"int", func(p *Params) (interface{}, error) { return p.Int("timeout", 5) }, 10, false},
+		{"int default", func(p *Params) (interface{}, error) { return p.Int("retries", 3) }, 3, false},
+		{"invalid int", func(p *Params) (interface{}, error) { return p.Int("port", 0) }, nil, true},
+		{"bool", func(p *Params) (interface{}, error) { return p.Bool("collect_response_time", true) }, false, false},
+		{"bool default", func(p *Params) (interface{}, error) { return p.Bool("verbose", true) }, true, false},
+		{"invalid bool", func(p *Params) (interface{}, error) { return p.Bool("ssl", false) }, nil, true},
+		{"string", func(p *Params) (interface{}, error) { return p.String("empty", "default"), nil }, "", false},
+		{"string default", func(p *Params) (interface{}, error) { return p.String("name", "default"), nil }, "default", false},
+		{"list", func(p *Params) (interface{}, error) { return p.List("keys"), nil }, []string{"a", "b"}, false},
+		{"empty list", func(p *Params) (interface{}, error) { return p.List("empty"), nil }, []string(nil), false},
+	}
+
+	for _, test := range tests {
+		value, err := test.read(ServiceParams("dd-tcp-check", service))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.name, value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%s: got %#v, want %#v", test.name, value, test.value)
+		}
+	}
+}
+
+func TestParamsUnused(t *testing.T) {
+	service := &consul.AgentService{
+		Meta: map[string]string{
+			"dd-php-fpm-fastcgi-HTTP_HOST": "app.local",
+			"dd-php-fpm-timout":            "10",
+		},
+		Tags: []string{
+			"dd-php-fpm:socket=/var/run/php/app.sock",
+			"dd-php-fpm:fastcgi-server-name=app",
+		},
+	}
+
+	params := ServiceParams("dd-php-fpm", service)
+
+	if got, want := params.Unused(), []string{"fastcgi_http_host", "fastcgi_server_name", "socket", "timout"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused() before reading = %v, want %v", got, want)
+	}
+
+	params.String("socket", "")
+	params.Int("timeout", 5)
+
+	prefixed := params.Prefixed("fastcgi_")
+	if want := map[string]string{"http_host": "app.local", "server_name": "app"}; !reflect.DeepEqual(prefixed, want) {
+		t.Errorf("Prefixed(fastcgi_) = %v, want %v", prefixed, want)
+	}
+
+	if got, want := params.Unused(), []string{"timout"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,589 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Settings is the daemon configuration, loaded from a YAML (or JSON) file
+type Settings struct {
+	ListenPort int                         `yaml:"listen_port"`
+	Agent      AgentSettings               `yaml:"agent"`
+	Consul     ConsulSettings              `yaml:"consul"`
+	Reload     ReloadSettings              `yaml:"reload"`
+	Retry      RetrySettings               `yaml:"retry"`
+	Validation ValidationSettings          `yaml:"validation"`
+	Tags       TagSettings                 `yaml:"tags"`
+	DogStatsD  DogStatsDSettings           `yaml:"dogstatsd"`
+	PHPFPM     PHPFPMSettings              `yaml:"php_fpm"`
+	Backends   map[string]*BackendSettings `yaml:"backends"`
+	Templates  []*TemplateSettings         `yaml:"templates"`
+}
+
+// Layouts of the dd-agent conf.d directory
+const (
+	// LayoutV5 writes a single /etc/dd-agent/conf.d/<check>.yaml file per backend
+	LayoutV5 = "v5"
+	// LayoutV6 writes to <conf_dir>/<check>.d/, as read by Agent v6 and v7
+	LayoutV6 = "v6"
+)
+
+// AgentSettings describes the datadog-agent we write configuration for
+type AgentSettings struct {
+	Layout         string `yaml:"layout"`
+	ConfDir        string `yaml:"conf_dir"`
+	FilePerService bool   `yaml:"file_per_service"`
+}
+
+// ConsulSettings controls how we watch the local Consul agent
+type ConsulSettings struct {
+	WaitTime time.Duration `yaml:"wait_time"`
+	RetryMin time.Duration `yaml:"retry_min"`
+	RetryMax time.Duration `yaml:"retry_max"`
+	// MaxUnreachable is how long Consul may be unreachable before /health/live fails
+	MaxUnreachable time.Duration `yaml:"max_unreachable"`
+	// ResyncInterval re-publishes the last snapshot to the backends, 0 disables it
+	ResyncInterval time.Duration `yaml:"resync_interval"`
+}
+
+// Backoff returns a new backoff for retrying failed Consul requests
+func (c ConsulSettings) Backoff() *Backoff {
+	return &Backoff{Min: c.RetryMin, Max: c.RetryMax}
+}
+
+// ReloadSettings controls how and when the datadog-agent is reloaded
+type ReloadSettings struct {
+	Disabled bool          `yaml:"disabled"`
+	Interval time.Duration `yaml:"interval"`
+	Strategy string        `yaml:"strategy"`
+	Timeout  time.Duration `yaml:"timeout"`
+
+	// Debounce waits until no changes were seen for this long before reloading
+	Debounce time.Duration `yaml:"debounce"`
+	// MaxWait bounds the debounce, the agent is reloaded once the first change is this old
+	MaxWait time.Duration `yaml:"max_wait"`
+	// MinInterval is the minimum time between two reloads
+	MinInterval time.Duration `yaml:"min_interval"`
+	// MaxPerHour caps the number of reloads in any hour, 0 means no cap
+	MaxPerHour int `yaml:"max_per_hour"`
+	// HistorySize is the number of reloads kept for the /reloads endpoint
+	HistorySize int `yaml:"history_size"`
+
+	// Unit is the service name used by the "service" and "systemd" strategies
+	Unit string `yaml:"unit"`
+	// Command is run by the "command" strategy
+	Command []string `yaml:"command"`
+	// PidFile and Signal are used by the "signal" strategy
+	PidFile string `yaml:"pid_file"`
+	Signal  string `yaml:"signal"`
+	// MarkerFile is written by the "marker" strategy, for agents watching it
+	MarkerFile string `yaml:"marker_file"`
+	// IPC configures the "ipc" strategy
+	IPC IPCSettings `yaml:"ipc"`
+}
+
+// IPCSettings configures reloading single checks through the Agent v6+ IPC API
+type IPCSettings struct {
+	// URL of the check reload endpoint, "{check}" is replaced with the check name
+	URL           string `yaml:"url"`
+	AuthTokenFile string `yaml:"auth_token_file"`
+	// Fallback is the strategy used when the API is unavailable
+	Fallback string `yaml:"fallback"`
+}
+
+// Reload strategies
+const (
+	ReloadService = "service"
+	ReloadSystemd = "systemd"
+	ReloadCommand = "command"
+	ReloadSignal  = "signal"
+	ReloadMarker  = "marker"
+	ReloadIPC     = "ipc"
+	ReloadNone    = "none"
+)
+
+// DogStatsDSettings controls the events and counts sent when monitoring changes
+type DogStatsDSettings struct {
+	Disabled  bool     `yaml:"disabled"`
+	Address   string   `yaml:"address"`
+	Namespace string   `yaml:"namespace"`
+	Tags      []string `yaml:"tags"`
+}
+
+// RetrySettings controls the backoff between retries of failed file writes and reloads
+type RetrySettings struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+}
+
+// Backoff returns a new backoff using the retry settings
+func (r RetrySettings) Backoff() *Backoff {
+	return &Backoff{Min: r.Min, Max: r.Max}
+}
+
+// ValidationSettings configures the command that checks changed files before the agent is reloaded
+type ValidationSettings struct {
+	// Command to run, "{path}" is replaced with the changed file (running the command once per file)
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// TagSettings controls which extra DataDog tags are added to every instance
+type TagSettings struct {
+	// Consul tags with this prefix are copied without it, "dd-tag-team:core" becomes "team:core"
+	Prefix string `yaml:"prefix"`
+	// Consul Meta keys to copy, mapped to the DataDog tag name, e.g. {"version": "version"}
+	Meta map[string]string `yaml:"meta"`
+	// Static tags added to every instance
+	Static []string `yaml:"static"`
+	// NodeTag adds "<node_tag>:<consul node name>" when not empty
+	NodeTag string `yaml:"node_tag"`
+}
+
+// BackendSettings configures a single backend, keyed by backend name
+type BackendSettings struct {
+	Enabled          *bool                  `yaml:"enabled"`
+	Path             string                 `yaml:"path"`
+	Tag              string                 `yaml:"tag"`
+	InstanceDefaults map[string]interface{} `yaml:"instance_defaults"`
+	Health           []string               `yaml:"health"`
+	HealthTag        bool                   `yaml:"health_tag"`
+}
+
+// PHPFPMSettings controls the fastcgi proxy used by the php-fpm check
+type PHPFPMSettings struct {
+	// Sockets are the glob patterns of the unix sockets the proxy may connect to,
+	// e.g. "/var/run/php/*.sock", no socket is allowed by default
+	Sockets []string `yaml:"sockets"`
+
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// ReadTimeout is the time php-fpm has to answer once connected
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// MaxConcurrent limits the requests in flight per php-fpm pool, 0 means no limit
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	KeepAlive   bool          `yaml:"keep_alive"`
+	MaxIdle     int           `yaml:"max_idle"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// StatusPath and PingPath are the pm.status_path and ping.path of the pools,
+	// "{project}" is replaced by the Consul service name
+	StatusPath string `yaml:"status_path"`
+	PingPath   string `yaml:"ping_path"`
+	PingReply  string `yaml:"ping_reply"`
+	// Params are extra FastCGI params sent with every request, e.g. {"HTTP_HOST": "status.local"}
+	Params map[string]string `yaml:"params"`
+}
+
+// AllowsSocket returns true if the (cleaned) socket path matches one of the patterns
+func (p *PHPFPMSettings) AllowsSocket(socket string) bool {
+	if !filepath.IsAbs(socket) || filepath.Clean(socket) != socket {
+		return false
+	}
+
+	for _, pattern := range p.Sockets {
+		if matched, _ := filepath.Match(pattern, socket); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TemplateSettings declares a backend that renders its instances from a template
+type TemplateSettings struct {
+	Name     string      `yaml:"name"`
+	Tag      string      `yaml:"tag"`
+	Check    string      `yaml:"check"`
+	Path     string      `yaml:"path"`
+	Instance interface{} `yaml:"instance"`
+}
+
+// IsEnabled returns true unless the backend has been explicitly disabled
+func (b *BackendSettings) IsEnabled() bool {
+	return b.Enabled == nil || *b.Enabled
+}
+
+// AllowsHealth returns true if services in the given health state should be monitored
+func (b *BackendSettings) AllowsHealth(health string) bool {
+	if len(b.Health) == 0 {
+		return true
+	}
+
+	for _, h := range b.Health {
+		if h == health {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultSettings returns the settings used when no config file is provided
+func DefaultSettings() *Settings {
+	return &Settings{
+		ListenPort: 4000,
+		Agent: AgentSettings{
+			Layout: LayoutV5,
+		},
+		Consul: ConsulSettings{
+			WaitTime:       5 * time.Minute,
+			RetryMin:       1 * time.Second,
+			RetryMax:       1 * time.Minute,
+			MaxUnreachable: 5 * time.Minute,
+			ResyncInterval: 5 * time.Minute,
+		},
+		Reload: ReloadSettings{
+			Interval:    1 * time.Second,
+			Strategy:    ReloadService,
+			Timeout:     30 * time.Second,
+			MinInterval: 5 * time.Second,
+			MaxWait:     5 * time.Minute,
+			HistorySize: 50,
+			Unit:        "datadog-agent",
+			Signal:      "HUP",
+			IPC: IPCSettings{
+				URL:           "https://localhost:5001/check/{check}/reload",
+				AuthTokenFile: "/etc/datadog-agent/auth_token",
+				Fallback:      ReloadSystemd,
+			},
+		},
+		Validation: ValidationSettings{
+			Timeout: 30 * time.Second,
+		},
+		Retry: RetrySettings{
+			Min: 1 * time.Second,
+			Max: 1 * time.Minute,
+		},
+		Tags: TagSettings{
+			Prefix: "dd-tag-",
+		},
+		DogStatsD: DogStatsDSettings{
+			Address:   "127.0.0.1:8125",
+			Namespace: "datadog_service_helper.",
+		},
+		PHPFPM: PHPFPMSettings{
+			DialTimeout:   2 * time.Second,
+			ReadTimeout:   5 * time.Second,
+			MaxConcurrent: 2,
+			MaxIdle:       1,
+			IdleTimeout:   30 * time.Second,
+			StatusPath:    "/{project}/internal/status",
+			PingPath:      "/{project}/internal/ping",
+			PingReply:     "pong",
+		},
+		Backends: make(map[string]*BackendSettings),
+	}
+}
+
+// LoadSettings reads the config file (if any) on top of the defaults and applies
+// the legacy environment variable overrides
+func LoadSettings(filePath string) (*Settings, error) {
+	settings := DefaultSettings()
+
+	if filePath != "" {
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read config file %s: %s", filePath, err)
+		}
+
+		if err := yaml.UnmarshalStrict(data, settings); err != nil {
+			return nil, fmt.Errorf("Could not parse config file %s: %s", filePath, err)
+		}
+
+		if settings.Backends == nil {
+			settings.Backends = make(map[string]*BackendSettings)
+		}
+	}
+
+	if settings.Agent.ConfDir == "" && settings.Agent.Layout == LayoutV6 {
+		settings.Agent.ConfDir = "/etc/datadog-agent/conf.d"
+	}
+
+	if err := settings.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// applyEnv keeps the environment variables from before the config file existed working
+func (s *Settings) applyEnv() error {
+	if port := os.Getenv("NOMAD_PORT_http"); port != "" {
+		i, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("Invalid NOMAD_PORT_http %q: %s", port, err)
+		}
+		s.ListenPort = i
+	}
+
+	// the variable set by the DataDog admission controller and most Nomad jobs
+	if host := os.Getenv("DD_AGENT_HOST"); host != "" {
+		_, port, err := net.SplitHostPort(s.DogStatsD.Address)
+		if err != nil {
+			port = "8125"
+		}
+		s.DogStatsD.Address = net.JoinHostPort(host, port)
+	}
+
+	if os.Getenv("DONT_RELOAD_DATADOG") != "" {
+		s.Reload.Disabled = true
+	}
+
+	if s.Reload.Disabled {
+		s.Reload.Strategy = ReloadNone
+	}
+
+	return nil
+}
+
+// validateStrategy checks the settings needed by a reload strategy
+func (r *ReloadSettings) validateStrategy(strategy string) []string {
+	switch strategy {
+	case ReloadService, ReloadSystemd:
+		if r.Unit == "" {
+			return []string{fmt.Sprintf("reload.unit must not be empty for the %s strategy", strategy)}
+		}
+	case ReloadCommand:
+		if len(r.Command) == 0 {
+			return []string{"reload.command must not be empty for the command strategy"}
+		}
+	case ReloadSignal:
+		if r.PidFile == "" {
+			return []string{"reload.pid_file must not be empty for the signal strategy"}
+		}
+	case ReloadMarker:
+		if r.MarkerFile == "" {
+			return []string{"reload.marker_file must not be empty for the marker strategy"}
+		}
+	case ReloadIPC, ReloadNone:
+	default:
+		return []string{fmt.Sprintf("reload strategy must be one of service, systemd, command, signal, marker, ipc or none, got %q", strategy)}
+	}
+
+	return nil
+}
+
+// minDuration is the shortest duration accepted in the settings, bare numbers
+// in the config file are read as nanoseconds so anything shorter is a mistake
+const minDuration = time.Second
+
+// checkDuration returns a problem if d is shorter than minDuration, optional durations may be 0
+func checkDuration(name string, d time.Duration, optional bool) []string {
+	if d == 0 && optional {
+		return nil
+	}
+
+	if d < minDuration {
+		return []string{fmt.Sprintf("%s must be at least %s, got %s (use a duration like \"30s\")", name, minDuration, d)}
+	}
+
+	return nil
+}
+
+// Backend returns the settings for a backend, empty settings if it isn't configured
+func (s *Settings) Backend(name string) *BackendSettings {
+	if b, found := s.Backends[name]; found && b != nil {
+		return b
+	}
+
+	return &BackendSettings{}
+}
+
+// Validate checks the settings for mistakes, knownBackends are the registered backend names
+func (s *Settings) Validate(knownBackends []string) error {
+	problems := make([]string, 0)
+
+	if s.ListenPort < 1 || s.ListenPort > 65535 {
+		problems = append(problems, fmt.Sprintf("listen_port must be between 1 and 65535, got %d", s.ListenPort))
+	}
+
+	switch s.Agent.Layout {
+	case LayoutV5:
+		if s.Agent.FilePerService {
+			problems = append(problems, "agent.file_per_service requires agent.layout v6")
+		}
+	case LayoutV6:
+	default:
+		problems = append(problems, fmt.Sprintf("agent.layout must be v5 or v6, got %q", s.Agent.Layout))
+	}
+
+	problems = append(problems, checkDuration("consul.wait_time", s.Consul.WaitTime, false)...)
+	problems = append(problems, checkDuration("consul.retry_min", s.Consul.RetryMin, false)...)
+	problems = append(problems, checkDuration("consul.max_unreachable", s.Consul.MaxUnreachable, false)...)
+	problems = append(problems, checkDuration("consul.resync_interval", s.Consul.ResyncInterval, true)...)
+
+	if s.Consul.RetryMax < s.Consul.RetryMin {
+		problems = append(problems, "consul.retry_max must not be smaller than consul.retry_min")
+	}
+
+	problems = append(problems, checkDuration("reload.interval", s.Reload.Interval, false)...)
+	problems = append(problems, checkDuration("reload.timeout", s.Reload.Timeout, false)...)
+	problems = append(problems, checkDuration("reload.debounce", s.Reload.Debounce, true)...)
+	problems = append(problems, checkDuration("reload.max_wait", s.Reload.MaxWait, true)...)
+	problems = append(problems, checkDuration("reload.min_interval", s.Reload.MinInterval, true)...)
+
+	if s.Reload.MaxPerHour < 0 {
+		problems = append(problems, "reload.max_per_hour must not be negative")
+	}
+
+	if s.Reload.HistorySize < 1 {
+		problems = append(problems, "reload.history_size must be at least 1")
+	}
+
+	problems = append(problems, s.Reload.validateStrategy(s.Reload.Strategy)...)
+
+	if s.Reload.Strategy == ReloadIPC {
+		if s.Reload.IPC.URL == "" {
+			problems = append(problems, "reload.ipc.url must not be empty for the ipc strategy")
+		}
+
+		if s.Reload.IPC.Fallback == ReloadIPC {
+			problems = append(problems, "reload.ipc.fallback must not be ipc")
+		} else {
+			problems = append(problems, s.Reload.validateStrategy(s.Reload.IPC.Fallback)...)
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, name := range knownBackends {
+		known[name] = true
+	}
+
+	// template backends can be tuned through "backends" like the built-in ones
+	allowed := make(map[string]bool)
+	for name := range known {
+		allowed[name] = true
+	}
+	for _, t := range s.Templates {
+		if t != nil && t.Name != "" {
+			allowed[t.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(s.Backends))
+	for name := range s.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !allowed[name] {
+			problems = append(problems, fmt.Sprintf("backends.%s: unknown backend (known: %s)", name, strings.Join(knownBackends, ", ")))
+			continue
+		}
+
+		b := s.Backends[name]
+		if b == nil {
+			continue
+		}
+
+		if b.Tag != "" && strings.TrimSpace(b.Tag) != b.Tag {
+			problems = append(problems, fmt.Sprintf("backends.%s.tag must not contain leading or trailing whitespace", name))
+		}
+
+		for _, health := range b.Health {
+			switch health {
+			case HealthPassing, HealthWarning, HealthCritical, HealthMaintenance:
+			default:
+				problems = append(problems, fmt.Sprintf("backends.%s.health: unknown state %q (known: passing, warning, critical, maintenance)", name, health))
+			}
+		}
+	}
+
+	if len(s.Validation.Command) > 0 {
+		problems = append(problems, checkDuration("validation.timeout", s.Validation.Timeout, false)...)
+	}
+
+	if !s.DogStatsD.Disabled {
+		if _, _, err := net.SplitHostPort(s.DogStatsD.Address); err != nil {
+			problems = append(problems, fmt.Sprintf("dogstatsd.address must be host:port, got %q", s.DogStatsD.Address))
+		}
+	}
+
+	problems = append(problems, checkDuration("retry.min", s.Retry.Min, false)...)
+
+	if s.Retry.Max < s.Retry.Min {
+		problems = append(problems, "retry.max must not be smaller than retry.min")
+	}
+
+	for key, tag := range s.Tags.Meta {
+		if tag == "" {
+			problems = append(problems, fmt.Sprintf("tags.meta.%s must not be empty", key))
+		}
+	}
+
+	for _, tag := range s.Tags.Static {
+		if tag == "" || strings.TrimSpace(tag) != tag {
+			problems = append(problems, fmt.Sprintf("tags.static: invalid tag %q", tag))
+		}
+	}
+
+	for _, pattern := range s.PHPFPM.Sockets {
+		if _, err := filepath.Match(pattern, ""); err != nil || !filepath.IsAbs(pattern) {
+			problems = append(problems, fmt.Sprintf("php_fpm.sockets: %q must be an absolute glob pattern", pattern))
+		}
+	}
+
+	problems = append(problems, checkDuration("php_fpm.dial_timeout", s.PHPFPM.DialTimeout, false)...)
+	problems = append(problems, checkDuration("php_fpm.read_timeout", s.PHPFPM.ReadTimeout, false)...)
+
+	if s.PHPFPM.MaxConcurrent < 0 || s.PHPFPM.MaxIdle < 0 {
+		problems = append(problems, "php_fpm.max_concurrent and php_fpm.max_idle must not be negative")
+	}
+
+	if !strings.HasPrefix(s.PHPFPM.StatusPath, "/") || !strings.HasPrefix(s.PHPFPM.PingPath, "/") {
+		problems = append(problems, "php_fpm.status_path and php_fpm.ping_path must start with /")
+	}
+
+	if s.PHPFPM.KeepAlive {
+		problems = append(problems, checkDuration("php_fpm.idle_timeout", s.PHPFPM.IdleTimeout, false)...)
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range s.Templates {
+		if t == nil {
+			continue
+		}
+
+		prefix := fmt.Sprintf("templates[%d]", i)
+		if t.Name != "" {
+			prefix = fmt.Sprintf("templates.%s", t.Name)
+		}
+
+		switch {
+		case t.Name == "":
+			problems = append(problems, prefix+".name must not be empty")
+		case known[t.Name] || seen[t.Name]:
+			problems = append(problems, fmt.Sprintf("%s: a backend named %s already exists", prefix, t.Name))
+		}
+		seen[t.Name] = true
+
+		if !strings.HasPrefix(t.Tag, "dd-") || len(t.Tag) <= len("dd-") {
+			problems = append(problems, fmt.Sprintf("%s.tag must start with 'dd-', got %q", prefix, t.Tag))
+		}
+
+		if t.Path == "" && s.Agent.Layout != LayoutV6 {
+			problems = append(problems, prefix+".path must not be empty unless agent.layout is v6")
+		}
+
+		if t.Instance == nil {
+			problems = append(problems, prefix+".instance must not be empty")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
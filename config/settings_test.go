@@ -0,0 +1,48 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"durations", "consul:\n  wait_time: 2m\nreload:\n  debounce: 0s\n", ""},
+		{"unknown key", "consul:\n  wait: 2m\n", "Could not parse"},
+		{"bare number", "consul:\n  wait_time: 300\n", "consul.wait_time must be at least 1s"},
+		{"sub-second", "reload:\n  interval: 500ms\n", "reload.interval must be at least 1s"},
+		{"optional", "reload:\n  min_interval: 5\n", "reload.min_interval must be at least 1s"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		settings, err := LoadSettings(path)
+		if err == nil {
+			err = settings.Validate(nil)
+		}
+
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package config
 
-import observer "github.com/imkira/go-observer"
+import (
+	consul "github.com/hashicorp/consul/api"
+	observer "github.com/imkira/go-observer"
+	"github.com/seatgeek/datadog-service-helper/dogstatsd"
+)
 
 type QuitChannel chan string
 
 type ReloadChannel chan ReloadPayload
 
 type ReloadPayload struct {
-	Service string
-	NewHash string
-	OldHash string
+	Service string `json:"service"`
+	Check   string `json:"check"`
+	NewHash string `json:"new_hash"`
+	OldHash string `json:"old_hash"`
 }
 
 type ServicePayload struct {
@@ -18,4 +23,21 @@ type ServicePayload struct {
 	QuitCh     QuitChannel
 	ReloadCh   ReloadChannel
 	ListenPort int
+	Settings   *Settings
+	// StatsD announces monitoring changes, it is nil when disabled
+	StatsD *dogstatsd.Client
 }
+
+// Service is a Consul service on the local node together with its aggregated health
+type Service struct {
+	*consul.AgentService
+	Health string
+}
+
+// Health states of a service, from best to worst
+const (
+	HealthPassing     = "passing"
+	HealthWarning     = "warning"
+	HealthCritical    = "critical"
+	HealthMaintenance = "maintenance"
+)
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ServiceTags returns the extra DataDog tags for an instance of the service
+func (t *TagSettings) ServiceTags(nodeName string, service *consul.AgentService) []string {
+	tags := make([]string, 0)
+	tags = append(tags, t.Static...)
+
+	if t.NodeTag != "" && nodeName != "" {
+		tags = append(tags, fmt.Sprintf("%s:%s", t.NodeTag, nodeName))
+	}
+
+	if t.Prefix != "" {
+		for _, tag := range service.Tags {
+			if strings.HasPrefix(tag, t.Prefix) && len(tag) > len(t.Prefix) {
+				tags = append(tags, strings.TrimPrefix(tag, t.Prefix))
+			}
+		}
+	}
+
+	// sort the meta keys so we get consistent output across runs
+	keys := make([]string, 0, len(t.Meta))
+	for key := range t.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if value, found := service.Meta[key]; found && value != "" {
+			tags = append(tags, fmt.Sprintf("%s:%s", t.Meta[key], value))
+		}
+	}
+
+	return tags
+}
@@ -1,41 +1,60 @@
 package config
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
-func WriteIfChange(service string, filePath string, data []byte, currentHash string) (bool, string) {
-	text := string(data)
-	text = "---\n" + text
-
-	// turn the text back to bytes for hashing
-	data = []byte(text)
+// WriteIfChange writes data to filePath unless its hash matches currentHash,
+// and returns whether the file changed together with the hash of its content
+func WriteIfChange(service string, filePath string, data []byte, currentHash string) (bool, string, error) {
+	data = fileContent(data)
 
 	// compare hash of the new content vs file on disk
 	newHash := HashBytes(data)
 	if newHash == currentHash {
 		logger.Infof("[%s] File hash is the same, NOOP", service)
-		return false, newHash
+		return false, newHash, nil
+	}
+
+	// write the file through a temp file + rename, so the agent never reads a partial file.
+	// the new hash is only returned once the file is in place, so a failed write is retried
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return false, currentHash, fmt.Errorf("Could not write file %s: %s", filePath, err)
 	}
 
-	// open file for write (truncated)
-	file, err := os.Create(filePath)
-	defer file.Close()
-	if err != nil {
-		logger.Fatalf("[%s] Could not create file %s: %s", service, filePath, err)
-		return false, newHash
+	logger.Infof("[%s] Successfully updated file: %s (old: %s | new: %s)", service, filePath, currentHash, newHash)
+	return true, newHash, nil
+}
+
+// ContentHash is the hash WriteIfChange returns after writing data
+func ContentHash(data []byte) string {
+	return HashBytes(fileContent(data))
+}
+
+// fileContent is data as written to disk, with the YAML document start
+func fileContent(data []byte) []byte {
+	return []byte("---\n" + string(data))
+}
+
+// WriteStaged writes data below dir as WriteIfChange would write it to filePath,
+// keeping the name of the file and its directory, so it can be checked before it is put in place
+func WriteStaged(dir string, filePath string, data []byte) (string, error) {
+	stagedPath := filepath.Join(dir, filepath.Base(filepath.Dir(filePath)), filepath.Base(filePath))
+
+	if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+		return "", err
 	}
 
-	// write file to disk
-	if _, err := file.Write(data); err != nil {
-		logger.Errorf("[%s] Could not write file %s: %s", service, filePath, err)
-		return false, newHash
+	if err := ioutil.WriteFile(stagedPath, fileContent(data), 0644); err != nil {
+		return "", err
 	}
 
-	logger.Infof("[%s] Successfully updated file: %s (old: %s | new: %s)", service, filePath, currentHash, newHash)
-	return true, newHash
+	return stagedPath, nil
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/seatgeek/datadog-service-helper/reloader"
+	"github.com/seatgeek/datadog-service-helper/services"
+)
+
+// dashboardReloads is how many reloads are shown on the dashboard
+const dashboardReloads = 10
+
+// dashboardErrors is how many errors are shown on the dashboard
+const dashboardErrors = 20
+
+// dashboardError is a single problem shown on the dashboard
+type dashboardError struct {
+	Time    time.Time
+	Source  string
+	Message string
+}
+
+// dashboard is everything rendered by dashboardTemplate
+type dashboard struct {
+	*status
+	Now     time.Time
+	Reloads []*reloader.HistoryEntry
+	Errors  []*dashboardError
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Truncate(time.Second).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>datadog-service-helper on {{ .NodeName }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.ok { color: #080; }
+.failed { color: #c00; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>datadog-service-helper on {{ .NodeName }}</h1>
+<p>Generated at {{ .Now.Format "2006-01-02 15:04:05 MST" }}, JSON version at <a href="/status">/status</a>.</p>
+
+<h2>Consul</h2>
+<table>
+<tr><th>Connected</th><td>{{ if .Consul.Connected }}<span class="ok">yes</span>{{ else }}<span class="failed">no</span>{{ end }}</td></tr>
+<tr><th>Last contact</th><td>{{ since .Consul.LastContact }}</td></tr>
+<tr><th>Last sync</th><td>{{ since .Consul.LastSync }}</td></tr>
+<tr><th>Services on node</th><td>{{ len .Services }}</td></tr>
+{{ if not .Consul.FailingSince.IsZero }}<tr><th>Failing since</th><td class="failed">{{ since .Consul.FailingSince }}</td></tr>{{ end }}
+</table>
+
+<h2>Backends</h2>
+<table>
+<tr><th>Backend</th><th>Check</th><th>Tag</th><th>Files</th><th>Instances</th><th>Skipped</th><th>Last sync</th><th>Last error</th></tr>
+{{ range .Backends }}<tr>
+<td>{{ .Name }}</td>
+<td>{{ .Check }}</td>
+<td><code>{{ .Tag }}</code></td>
+<td>{{ range $path, $hash := .Files }}<code>{{ $path }}</code> <code>{{ $hash }}</code><br>{{ else }}none{{ end }}</td>
+<td>{{ len .Instances }}</td>
+<td>{{ len .Skipped }}</td>
+<td>{{ since .LastSync }}</td>
+<td>{{ if .LastError }}<span class="failed">{{ .LastError }}</span> ({{ since .LastErrorAt }}){{ end }}</td>
+</tr>{{ else }}<tr><td colspan="8">No backend has synced yet</td></tr>{{ end }}
+</table>
+
+<h2>Recent reloads</h2>
+<table>
+<tr><th>Time</th><th>Strategy</th><th>Changes</th><th>Duration</th><th>Result</th></tr>
+{{ range .Reloads }}<tr>
+<td>{{ since .Time }}</td>
+<td>{{ .Strategy }}</td>
+<td>{{ range .Changes }}{{ .Service }} <code>{{ .OldHash }}</code> &rarr; <code>{{ .NewHash }}</code><br>{{ end }}</td>
+<td>{{ .Duration }}</td>
+<td>{{ if .Success }}<span class="ok">ok</span>{{ else }}<span class="failed">{{ .Error }}</span>{{ end }}</td>
+</tr>{{ else }}<tr><td colspan="5">No reloads yet</td></tr>{{ end }}
+</table>
+
+<h2>Recent errors</h2>
+<table>
+<tr><th>Time</th><th>Source</th><th>Error</th></tr>
+{{ range .Errors }}<tr>
+<td>{{ since .Time }}</td>
+<td>{{ .Source }}</td>
+<td class="failed">{{ .Message }}</td>
+</tr>{{ else }}<tr><td colspan="3">No errors</td></tr>{{ end }}
+</table>
+</body>
+</html>
+`))
+
+// buildDashboard collects the status, reloads and the most recent errors of all components
+func buildDashboard(nodeName string, history []*reloader.HistoryEntry) *dashboard {
+	d := &dashboard{
+		status:  buildStatus(nodeName),
+		Now:     time.Now(),
+		Reloads: history,
+		Errors:  make([]*dashboardError, 0),
+	}
+
+	if len(d.Reloads) > dashboardReloads {
+		d.Reloads = d.Reloads[:dashboardReloads]
+	}
+
+	for _, e := range services.RecentErrors() {
+		d.Errors = append(d.Errors, &dashboardError{Time: e.Time, Source: e.Source, Message: e.Message})
+	}
+
+	for _, entry := range history {
+		if !entry.Success {
+			d.Errors = append(d.Errors, &dashboardError{Time: entry.Time, Source: "reload", Message: entry.Error})
+		}
+	}
+
+	sort.Sort(dashboardErrorSorter(d.Errors))
+	if len(d.Errors) > dashboardErrors {
+		d.Errors = d.Errors[:dashboardErrors]
+	}
+
+	return d
+}
+
+// dashboardHandler serves a human friendly HTML version of /status and /reloads
+func dashboardHandler(nodeName string, r *reloader.Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		if err := dashboardTemplate.Execute(&buf, buildDashboard(nodeName, r.History())); err != nil {
+			message := fmt.Sprintf("[dashboardHandler] Could not render dashboard: %s", err)
+			logger.Error(message)
+			http.Error(w, message, 500)
+			return
+		}
+
+		w.Header().Add("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
+
+// dashboardErrorSorter sorts errors newest first
+type dashboardErrorSorter []*dashboardError
+
+func (a dashboardErrorSorter) Len() int           { return len(a) }
+func (a dashboardErrorSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a dashboardErrorSorter) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
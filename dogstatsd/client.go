@@ -0,0 +1,106 @@
+// Package dogstatsd sends events and counts to the DogStatsD server of the local datadog-agent
+package dogstatsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.New()
+
+// Alert types of an event
+const (
+	AlertInfo    = "info"
+	AlertSuccess = "success"
+	AlertError   = "error"
+)
+
+// Event is shown in the DataDog event stream
+type Event struct {
+	Title     string
+	Text      string
+	AlertType string
+	Tags      []string
+}
+
+// Client sends metrics and events over UDP, it never fails: if nothing listens
+// on the address the datagrams are dropped. A nil client sends nothing.
+type Client struct {
+	address   string
+	namespace string
+	tags      []string
+	conn      net.Conn
+	mutex     sync.Mutex
+}
+
+// New returns a client for the DogStatsD server at address, namespace is added to
+// the name of every metric and tags to every metric and event
+func New(address, namespace string, tags []string) *Client {
+	return &Client{address: address, namespace: namespace, tags: tags}
+}
+
+// Count increments the counter name by value
+func (c *Client) Count(name string, value int64, tags ...string) {
+	if c == nil {
+		return
+	}
+
+	c.send(fmt.Sprintf("%s%s:%d|c%s", c.namespace, name, value, c.formatTags(tags)))
+}
+
+// Event sends an event to the event stream
+func (c *Client) Event(e *Event) {
+	if c == nil {
+		return
+	}
+
+	title := escapeEvent(e.Title)
+	text := escapeEvent(e.Text)
+
+	payload := fmt.Sprintf("_e{%d,%d}:%s|%s|s:datadog-service-helper", len(title), len(text), title, text)
+	if e.AlertType != "" {
+		payload += "|t:" + e.AlertType
+	}
+
+	c.send(payload + c.formatTags(e.Tags))
+}
+
+func (c *Client) formatTags(tags []string) string {
+	all := append(append([]string{}, c.tags...), tags...)
+	if len(all) == 0 {
+		return ""
+	}
+
+	return "|#" + strings.Join(all, ",")
+}
+
+// send a single datagram, (re)connecting if needed
+func (c *Client) send(payload string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.Dial("udp", c.address)
+		if err != nil {
+			logger.Debugf("[dogstatsd] Could not connect to %s: %s", c.address, err)
+			return
+		}
+		c.conn = conn
+	}
+
+	// a connected UDP socket reports "connection refused" when nothing listens,
+	// start over with a fresh socket next time
+	if _, err := c.conn.Write([]byte(payload)); err != nil {
+		logger.Debugf("[dogstatsd] Could not send to %s: %s", c.address, err)
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func escapeEvent(text string) string {
+	return strings.Replace(text, "\n", "\\n", -1)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/reloader"
+	"github.com/seatgeek/datadog-service-helper/services"
+)
+
+// healthChecker answers the liveness and readiness checks of e.g. Nomad
+type healthChecker struct {
+	settings *cfg.Settings
+	reloader *reloader.Reloader
+	// backends are the names of the enabled backends, which must all sync before we are ready
+	backends []string
+}
+
+// healthResponse is the JSON document served by the health endpoints
+type healthResponse struct {
+	Status   string   `json:"status"`
+	Problems []string `json:"problems"`
+}
+
+// unhealthy returns why the helper is not working, Consul being unreachable for
+// too long or the last reload having failed
+func (h *healthChecker) unhealthy(now time.Time) []string {
+	problems := make([]string, 0)
+
+	consul := consulTracker.status()
+	if !consul.FailingSince.IsZero() && now.Sub(consul.FailingSince) > h.settings.Consul.MaxUnreachable {
+		problems = append(problems, fmt.Sprintf("Consul unreachable since %s: %s", consul.FailingSince.Format(time.RFC3339), consul.LastError))
+	}
+
+	if history := h.reloader.History(); len(history) > 0 && !history[0].Success {
+		problems = append(problems, fmt.Sprintf("last reload of datadog-agent failed: %s", history[0].Error))
+	}
+
+	return problems
+}
+
+// notReady returns what we are still waiting for, the first Consul sync and the
+// initial file of every backend
+func (h *healthChecker) notReady() []string {
+	problems := make([]string, 0)
+
+	if consulTracker.status().LastSync.IsZero() {
+		problems = append(problems, "waiting for the first Consul sync")
+	}
+
+	synced := make(map[string]bool)
+	for _, status := range services.Statuses() {
+		synced[status.Name] = !status.LastSync.IsZero()
+	}
+
+	for _, name := range h.backends {
+		if !synced[name] {
+			problems = append(problems, fmt.Sprintf("waiting for backend %s to write its initial file", name))
+		}
+	}
+
+	return problems
+}
+
+// live fails when the helper is running but not doing its job
+func (h *healthChecker) live(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, "unhealthy", h.unhealthy(time.Now()))
+}
+
+// ready fails until the helper has synced once, and whenever it isn't live
+func (h *healthChecker) ready(w http.ResponseWriter, r *http.Request) {
+	problems := append(h.notReady(), h.unhealthy(time.Now())...)
+	h.respond(w, "not_ready", problems)
+}
+
+func (h *healthChecker) respond(w http.ResponseWriter, failed string, problems []string) {
+	response := &healthResponse{Status: "ok", Problems: problems}
+	code := http.StatusOK
+
+	if len(problems) > 0 {
+		response.Status = failed
+		code = http.StatusServiceUnavailable
+	}
+
+	resp, err := json.Marshal(response)
+	if err != nil {
+		message := fmt.Sprintf("[healthChecker] Could not marshal JSON: %s", err)
+		logger.Error(message)
+		http.Error(w, message, 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(resp)
+}
@@ -1,23 +1,28 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
 
 	"os/signal"
 	"syscall"
 	"time"
 
 	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/dogstatsd"
+	"github.com/seatgeek/datadog-service-helper/metrics"
 
 	reloader "github.com/seatgeek/datadog-service-helper/reloader"
-	go_expvar "github.com/seatgeek/datadog-service-helper/services/goexpvar"
+	"github.com/seatgeek/datadog-service-helper/services"
+	_ "github.com/seatgeek/datadog-service-helper/services/goexpvar"
 	php_fpm "github.com/seatgeek/datadog-service-helper/services/phpfpm"
-	"github.com/seatgeek/datadog-service-helper/services/redisdb"
-	"github.com/seatgeek/datadog-service-helper/services/tcp"
+	_ "github.com/seatgeek/datadog-service-helper/services/redisdb"
+	_ "github.com/seatgeek/datadog-service-helper/services/tcp"
+	"github.com/seatgeek/datadog-service-helper/services/template"
 
 	"github.com/gorilla/mux"
 	consul "github.com/hashicorp/consul/api"
@@ -28,12 +33,44 @@ import (
 )
 
 var logger = logrus.New()
-var consulServices = observer.NewProperty(make(map[string]*consul.AgentService, 0))
-var listenPort = getListenPort()
+var consulServices = observer.NewProperty(make(map[string]*cfg.Service, 0))
+var listenPort int
 
 func main() {
+	configFile := flag.String("config", os.Getenv("DATADOG_SERVICE_HELPER_CONFIG"), "path to the YAML or JSON config file")
+	flag.Parse()
+
 	logger.Info("Starting datadog monitoring ")
 
+	// seed the jitter used in retry backoffs, so nodes don't retry in lockstep
+	rand.Seed(time.Now().UnixNano())
+
+	// Load and validate our own configuration
+	settings, err := cfg.LoadSettings(*configFile)
+	if err != nil {
+		logger.Fatalf("Could not load configuration: %s", err)
+	}
+
+	if err := settings.Validate(services.Names()); err != nil {
+		logger.Fatalf("%s", err)
+	}
+
+	// register the template backends declared in the config file
+	for _, t := range settings.Templates {
+		if t == nil {
+			continue
+		}
+
+		backend, err := template.New(t)
+		if err != nil {
+			logger.Fatalf("Invalid configuration: %s", err)
+		}
+
+		services.Register(backend)
+	}
+
+	listenPort = settings.ListenPort
+
 	// Create consul client
 	config := consul.DefaultConfig()
 	client, err := consul.NewClient(config)
@@ -41,10 +78,15 @@ func main() {
 		logger.Fatalf("Could not connect to Consul backend: %s", err)
 	}
 
-	// Get local agent information
+	// Get local agent information, the agent might still be starting so keep trying
+	backoff := settings.Consul.Backoff()
 	self, err := client.Agent().Self()
-	if err != nil {
-		logger.Fatalf("Could not look up self(): %s", err)
+	for err != nil {
+		delay := backoff.Next()
+		logger.Errorf("Could not look up self() (retrying in %s): %s", delay, err)
+		time.Sleep(delay)
+
+		self, err = client.Agent().Self()
 	}
 
 	// look up the agent node name
@@ -62,33 +104,63 @@ func main() {
 		ListenPort: listenPort,
 		QuitCh:     quitCh,
 		ReloadCh:   reloadCh,
+		Settings:   settings,
+	}
+
+	if !settings.DogStatsD.Disabled {
+		payload.StatsD = dogstatsd.New(settings.DogStatsD.Address, settings.DogStatsD.Namespace, settings.DogStatsD.Tags)
 	}
 
-	reloader := reloader.NewReloader(payload)
+	reloader, err := reloader.NewReloader(payload)
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %s", err)
+	}
 
 	// start monitoring of consul services
-	go monitor(client, quitCh)
+	go monitor(client, nodeName, settings.Consul, quitCh)
 
 	// start the reloader
 	go reloader.Start()
 
-	// start service observers
-	go php_fpm.Observe(payload)
-	go go_expvar.Observe(payload)
-	go redisdb.Observe(payload)
-	go tcp.Observe(payload)
+	health := &healthChecker{settings: settings, reloader: reloader}
+
+	// start service observers for all registered backends
+	for _, backend := range services.Backends() {
+		if !settings.Backend(backend.Name()).IsEnabled() {
+			logger.Infof("Backend %s is disabled", backend.Name())
+			continue
+		}
+
+		health.backends = append(health.backends, backend.Name())
+		go services.Observe(backend, payload)
+	}
 
 	// start the http reserver that proxies http requests to php-cgi
 	router := mux.NewRouter()
 	router.Handle("/debug/vars", http.DefaultServeMux)
 	router.HandleFunc("/datadog/expvar", showExpVar)
-	router.HandleFunc("/php-fpm/{project}/{ip}/{port}/{type}", php_fpm.Proxy)
+	router.HandleFunc("/reloads", reloader.HistoryHandler)
+	router.HandleFunc("/", dashboardHandler(nodeName, reloader))
+	router.HandleFunc("/status", statusHandler(nodeName))
+	router.HandleFunc("/health/live", health.live)
+	router.HandleFunc("/health/ready", health.ready)
+	router.HandleFunc("/metrics", metrics.Handler)
+	proxy := php_fpm.NewProxy(payload)
+	router.HandleFunc("/php-fpm/{project}/{ip}/{port}/{type}", proxy.TCP)
+	router.HandleFunc("/php-fpm-unix/{project}/{type}", proxy.Unix)
 
 	logger.Infof("")
 	logger.Info("Entrypoints:")
+	logger.Infof("  - http://127.0.0.1:%d/", listenPort)
 	logger.Infof("  - http://127.0.0.1:%d/debug/vars", listenPort)
 	logger.Infof("  - http://127.0.0.1:%d/datadog/expvar", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/reloads", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/status", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/health/live", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/health/ready", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/metrics", listenPort)
 	logger.Infof("  - http://127.0.0.1:%d/php-fpm/{project}/{ip}/{port}/{type}", listenPort)
+	logger.Infof("  - http://127.0.0.1:%d/php-fpm-unix/{project}/{type}?socket={path}", listenPort)
 	logger.Infof("")
 
 	// create logger for http server
@@ -133,45 +205,12 @@ func main() {
 	logger.Info("end of program")
 }
 
-// Monitor consul services and emit updates when they change
-func monitor(client *consul.Client, quitCh chan string) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-quitCh:
-			logger.Warn("Stopping monitorConsulServices")
-			return
-
-		case <-ticker.C:
-			services, err := client.Agent().Services()
-			if err != nil {
-				logger.Warnf("Could not fetch Consul services: %s", err)
-			}
-
-			consulServices.Update(services)
-		}
-	}
-}
-
-func getListenPort() int {
-	port := os.Getenv("NOMAD_PORT_http")
-	if port == "" {
-		return 4000
-	}
-
-	i, err := strconv.Atoi(port)
-	if err != nil {
-		logger.Fatalf(err.Error())
-	}
-
-	return i
-}
-
 func showExpVar(w http.ResponseWriter, r *http.Request) {
-	metrics := make([]map[string]string, 0)
-	metrics = append(metrics, map[string]string{"path": "datadog_agent_reloads"})
+	// every expvar published through the metrics package, with its DataDog type
+	list := make([]map[string]string, 0)
+	for _, metric := range metrics.ExpvarMetrics() {
+		list = append(list, map[string]string{"path": metric.Path, "type": metric.Type})
+	}
 
 	config := struct {
 		ExpvarURL string              `yaml:"expvar_url"`
@@ -180,7 +219,7 @@ func showExpVar(w http.ResponseWriter, r *http.Request) {
 	}{
 		fmt.Sprintf("http://127.0.0.1:%d/debug/vars", listenPort),
 		[]string{"service:datadog-service-helper"},
-		metrics,
+		list,
 	}
 
 	resp, err := yaml.Marshal(&config)
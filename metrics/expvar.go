@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+)
+
+// How the DataDog go_expvar check should submit an expvar
+const (
+	// Gauge is submitted as is, e.g. the number of instances
+	Gauge = "gauge"
+	// Rate is submitted as the change per second, e.g. an error counter
+	Rate = "rate"
+)
+
+// expvarType remembers the DataDog type of a published expvar
+type expvarType struct {
+	name string
+	kind string
+	v    expvar.Var
+}
+
+var (
+	expvars      = make(map[string]*expvarType)
+	expvarsMutex sync.Mutex
+)
+
+func publish(name, kind string, v expvar.Var) {
+	expvar.Publish(name, v)
+
+	expvarsMutex.Lock()
+	expvars[name] = &expvarType{name: name, kind: kind, v: v}
+	expvarsMutex.Unlock()
+}
+
+// NewExpvarInt publishes an integer expvar of the DataDog type kind
+func NewExpvarInt(name, kind string) *expvar.Int {
+	v := new(expvar.Int)
+	publish(name, kind, v)
+	return v
+}
+
+// NewExpvarFloat publishes a float expvar of the DataDog type kind
+func NewExpvarFloat(name, kind string) *expvar.Float {
+	v := new(expvar.Float)
+	publish(name, kind, v)
+	return v
+}
+
+// NewExpvarMap publishes a map of expvars of the DataDog type kind, e.g. one per backend
+func NewExpvarMap(name, kind string) *expvar.Map {
+	v := new(expvar.Map).Init()
+	publish(name, kind, v)
+	return v
+}
+
+// SetExpvarGauge sets key in a map published with NewExpvarMap to value
+func SetExpvarGauge(m *expvar.Map, key string, value int64) {
+	v := new(expvar.Int)
+	v.Set(value)
+	m.Set(key, v)
+}
+
+// ExpvarMetric is a metric in the DataDog go_expvar check config
+type ExpvarMetric struct {
+	Path string
+	Type string
+}
+
+// ExpvarMetrics lists all expvars published through this package, map entries
+// are listed by key ("backend_errors/php-fpm") as the go_expvar check can't walk maps
+func ExpvarMetrics() []*ExpvarMetric {
+	expvarsMutex.Lock()
+	names := make([]string, 0, len(expvars))
+	published := make(map[string]*expvarType, len(expvars))
+	for name, e := range expvars {
+		names = append(names, name)
+		published[name] = e
+	}
+	expvarsMutex.Unlock()
+
+	sort.Strings(names)
+
+	list := make([]*ExpvarMetric, 0, len(names))
+	for _, name := range names {
+		e := published[name]
+
+		m, ok := e.v.(*expvar.Map)
+		if !ok {
+			list = append(list, &ExpvarMetric{Path: e.name, Type: e.kind})
+			continue
+		}
+
+		// Do walks the keys in sorted order
+		m.Do(func(kv expvar.KeyValue) {
+			list = append(list, &ExpvarMetric{Path: e.name + "/" + kv.Key, Type: e.kind})
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	registryMutex.Lock()
+	families := make([]*family, 0, len(registry))
+	for _, f := range registry {
+		families = append(families, f)
+	}
+	registryMutex.Unlock()
+
+	sort.Sort(familySorter(families))
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.write(&buf)
+	}
+
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// write the family in the Prometheus text format
+func (f *family) write(buf *bytes.Buffer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+
+		if f.kind != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, f.labelPairs(s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labels, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, f.labelPairs(s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, f.labelPairs(s.labels, "", ""), s.count)
+	}
+}
+
+// labelPairs formats the labels as {name="value",...}, with an optional extra label
+func (f *family) labelPairs(values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], escapeLabel(value)))
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// familySorter sorts families by name
+type familySorter []*family
+
+func (a familySorter) Len() int           { return len(a) }
+func (a familySorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a familySorter) Less(i, j int) bool { return a[i].name < a[j].name }
@@ -0,0 +1,144 @@
+// Package metrics is a minimal implementation of Prometheus counters, gauges and
+// histograms, served in the Prometheus text format on /metrics
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix is added to the name of every metric
+const Prefix = "datadog_service_helper_"
+
+// DefaultBuckets are the histogram buckets (in seconds) for short operations
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family is a metric and all its label combinations
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+	mutex   sync.Mutex
+}
+
+// series is a single label combination of a metric
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+var (
+	registry      = make(map[string]*family)
+	registryMutex sync.Mutex
+)
+
+func register(name, help, kind string, buckets []float64, labels []string) *family {
+	f := &family{
+		name:    Prefix + name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, found := registry[f.name]; found {
+		panic(fmt.Sprintf("metrics: %s registered twice", f.name))
+	}
+
+	registry[f.name] = f
+	return f
+}
+
+// with runs fn for the series with the label values, creating it if needed
+func (f *family) with(values []string, fn func(s *series)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects labels %v, got %v", f.name, f.labels, values))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	s, found := f.series[key]
+	if !found {
+		s = &series{labels: append([]string{}, values...), counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+
+	fn(s)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	f *family
+}
+
+// NewCounterVec registers a counter, labels are the names of its labels
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: register(name, help, "counter", nil, labels)}
+}
+
+// Inc adds one to the counter with the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta to the counter with the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	c.f.with(values, func(s *series) { s.value += delta })
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	f *family
+}
+
+// NewGaugeVec registers a gauge, labels are the names of its labels
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: register(name, help, "gauge", nil, labels)}
+}
+
+// Set the gauge with the label values
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.f.with(values, func(s *series) { s.value = value })
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogramVec registers a histogram with the (sorted) bucket upper bounds
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{f: register(name, help, "histogram", buckets, labels)}
+}
+
+// Observe adds a value to the histogram with the label values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.f.with(values, func(s *series) {
+		s.value += value
+		s.count++
+		for i, bound := range h.f.buckets {
+			if value <= bound {
+				s.counts[i]++
+			}
+		}
+	})
+}
+
+// Since observes the seconds passed since start
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
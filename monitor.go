@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/metrics"
+	"github.com/seatgeek/datadog-service-helper/services"
+)
+
+var consulErrors = metrics.NewExpvarInt("consul_errors", metrics.Rate)
+var consulRequests = metrics.NewCounterVec("consul_requests_total", "Consul blocking queries by endpoint and result.", "endpoint", "result")
+var consulBlockingQueryDuration = metrics.NewHistogramVec("consul_blocking_query_duration_seconds",
+	"Duration of Consul blocking queries by endpoint and result, successful ones include the wait for changes (up to consul.wait_time).",
+	[]float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 120, 300, 600}, "endpoint", "result")
+
+// consulState tracks how well we are talking to Consul, for the status pages
+type consulState struct {
+	mutex       sync.RWMutex
+	lastContact time.Time
+	lastSync    time.Time
+	lastError   string
+	lastErrorAt time.Time
+	failing     map[string]time.Time
+}
+
+// consulStatus is a point in time copy of consulState
+type consulStatus struct {
+	Connected    bool      `json:"connected"`
+	LastContact  time.Time `json:"last_contact"`
+	LastSync     time.Time `json:"last_sync"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at"`
+	FailingSince time.Time `json:"failing_since"`
+}
+
+var consulTracker = &consulState{failing: make(map[string]time.Time)}
+
+// success records a successful query
+func (c *consulState) success(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastContact = time.Now()
+	delete(c.failing, name)
+}
+
+// failure records a failed query, keeping the time it started failing
+func (c *consulState) failure(name string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastError = err.Error()
+	c.lastErrorAt = time.Now()
+	services.RecordError("consul", err)
+	if _, found := c.failing[name]; !found {
+		c.failing[name] = c.lastErrorAt
+	}
+}
+
+// synced records that a new snapshot was published to the backends
+func (c *consulState) synced() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastSync = time.Now()
+}
+
+func (c *consulState) status() consulStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	status := consulStatus{
+		Connected:   !c.lastContact.IsZero() && len(c.failing) == 0,
+		LastContact: c.lastContact,
+		LastSync:    c.lastSync,
+		LastError:   c.lastError,
+		LastErrorAt: c.lastErrorAt,
+	}
+
+	for _, since := range c.failing {
+		if status.FailingSince.IsZero() || since.Before(status.FailingSince) {
+			status.FailingSince = since
+		}
+	}
+
+	return status
+}
+
+// watcher combines the services and health checks of our node into a single snapshot
+type watcher struct {
+	mutex    sync.Mutex
+	services map[string]*consul.AgentService
+	checks   consul.HealthChecks
+}
+
+// Monitor consul services and emit updates when they change
+//
+// We use blocking queries against the catalog and health endpoints for our own node,
+// so changes are seen as soon as they happen and idle nodes barely make any requests
+func monitor(client *consul.Client, nodeName string, settings cfg.ConsulSettings, quitCh chan string) {
+	w := &watcher{}
+
+	if settings.ResyncInterval > 0 {
+		go w.resync(settings.ResyncInterval, quitCh)
+	}
+
+	go blockingQuery("services", settings, quitCh, func(opts *consul.QueryOptions) (uint64, error) {
+		node, meta, err := client.Catalog().Node(nodeName, opts)
+		if err != nil {
+			return 0, err
+		}
+
+		services := make(map[string]*consul.AgentService)
+		if node != nil {
+			services = node.Services
+		}
+
+		w.mutex.Lock()
+		w.services = services
+		w.mutex.Unlock()
+
+		return meta.LastIndex, nil
+	}, w.publish)
+
+	blockingQuery("health", settings, quitCh, func(opts *consul.QueryOptions) (uint64, error) {
+		checks, meta, err := client.Health().Node(nodeName, opts)
+		if err != nil {
+			return 0, err
+		}
+
+		if checks == nil {
+			checks = consul.HealthChecks{}
+		}
+
+		w.mutex.Lock()
+		w.checks = checks
+		w.mutex.Unlock()
+
+		return meta.LastIndex, nil
+	}, w.publish)
+}
+
+// publish the current snapshot, once both services and health checks are known
+func (w *watcher) publish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.services == nil || w.checks == nil {
+		return
+	}
+
+	snapshot := make(map[string]*cfg.Service)
+	for id, service := range w.services {
+		snapshot[id] = &cfg.Service{
+			AgentService: service,
+			Health:       serviceHealth(id, w.checks),
+		}
+	}
+
+	consulServices.Update(snapshot)
+	consulTracker.synced()
+}
+
+// resync publishes the current snapshot again every interval, so backends retry the
+// services they skipped (e.g. a failed remote config fetch) even when Consul is quiet
+func (w *watcher) resync(interval time.Duration, quitCh chan string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quitCh:
+			return
+
+		case <-ticker.C:
+			w.publish()
+		}
+	}
+}
+
+// blockingQuery runs query in a loop, passing the last seen index so Consul only answers
+// once something changed (or the wait time passed), and calls onChange for every new index
+func blockingQuery(name string, settings cfg.ConsulSettings, quitCh chan string, query func(*consul.QueryOptions) (uint64, error), onChange func()) {
+	backoff := settings.Backoff()
+	var lastIndex uint64
+
+	for {
+		select {
+		case <-quitCh:
+			logger.Warnf("Stopping monitorConsulServices (%s)", name)
+			return
+
+		default:
+		}
+
+		start := time.Now()
+		index, err := query(&consul.QueryOptions{
+			WaitIndex: lastIndex,
+			WaitTime:  settings.WaitTime,
+		})
+
+		if err != nil {
+			consulBlockingQueryDuration.Since(start, name, "failure")
+			consulRequests.Inc(name, "failure")
+			consulErrors.Add(1)
+			consulTracker.failure(name, err)
+
+			delay := backoff.Next()
+			logger.Warnf("Could not fetch Consul %s (retrying in %s): %s", name, delay, err)
+
+			select {
+			case <-quitCh:
+				logger.Warnf("Stopping monitorConsulServices (%s)", name)
+				return
+
+			case <-time.After(delay):
+			}
+
+			continue
+		}
+
+		backoff.Reset()
+		consulBlockingQueryDuration.Since(start, name, "success")
+		consulRequests.Inc(name, "success")
+		consulTracker.success(name)
+
+		// nothing changed, the blocking query just timed out
+		if index == lastIndex {
+			continue
+		}
+
+		// the index went backwards (e.g. a Consul snapshot restore), start over
+		if index < lastIndex {
+			lastIndex = 0
+		} else {
+			lastIndex = index
+		}
+
+		onChange()
+	}
+}
+
+// serviceHealth aggregates the checks of a service (and its node) into a single state
+func serviceHealth(serviceID string, checks consul.HealthChecks) string {
+	health := cfg.HealthPassing
+
+	for _, check := range checks {
+		if check.ServiceID != "" && check.ServiceID != serviceID {
+			continue
+		}
+
+		// maintenance mode is implemented by Consul as a critical check with a well known ID
+		if check.CheckID == "_node_maintenance" || strings.HasPrefix(check.CheckID, "_service_maintenance:") {
+			return cfg.HealthMaintenance
+		}
+
+		switch check.Status {
+		case consul.HealthCritical:
+			health = cfg.HealthCritical
+		case consul.HealthWarning:
+			if health == cfg.HealthPassing {
+				health = cfg.HealthWarning
+			}
+		}
+	}
+
+	return health
+}
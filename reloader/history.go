@@ -0,0 +1,91 @@
+package reloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// HistoryEntry describes a single reload of the datadog-agent
+type HistoryEntry struct {
+	Time     time.Time           `json:"time"`
+	Strategy string              `json:"strategy"`
+	Changes  []cfg.ReloadPayload `json:"changes"`
+	Duration string              `json:"duration"`
+	Success  bool                `json:"success"`
+	Error    string              `json:"error,omitempty"`
+	Output   string              `json:"output,omitempty"`
+}
+
+// checks returns the DataDog checks affected by the reload
+func (e *HistoryEntry) checks() []string {
+	seen := make(map[string]bool)
+	checks := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		if !seen[change.Check] {
+			seen[change.Check] = true
+			checks = append(checks, change.Check)
+		}
+	}
+
+	sort.Strings(checks)
+	return checks
+}
+
+// history is a ring buffer of the most recent reloads
+type history struct {
+	entries []*HistoryEntry
+	next    int
+	size    int
+	mutex   sync.RWMutex
+}
+
+func newHistory(size int) *history {
+	return &history{entries: make([]*HistoryEntry, size), size: size}
+}
+
+func (h *history) add(entry *HistoryEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % h.size
+}
+
+// list returns the recorded reloads, newest first
+func (h *history) list() []*HistoryEntry {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	list := make([]*HistoryEntry, 0, h.size)
+	for i := 1; i <= h.size; i++ {
+		if entry := h.entries[(h.next-i+h.size)%h.size]; entry != nil {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// History returns the most recent reloads, newest first
+func (r *Reloader) History() []*HistoryEntry {
+	return r.history.list()
+}
+
+// HistoryHandler shows the most recent reloads as JSON
+func (r *Reloader) HistoryHandler(w http.ResponseWriter, req *http.Request) {
+	resp, err := json.MarshalIndent(r.History(), "", "  ")
+	if err != nil {
+		message := "[reloader] Could not marshal JSON: " + err.Error()
+		logger.Error(message)
+		http.Error(w, message, 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resp)
+}
@@ -0,0 +1,103 @@
+package reloader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// fakeStrategy records the reloads it was asked for
+type fakeStrategy struct {
+	reloads [][]string
+	err     error
+}
+
+func (s *fakeStrategy) Name() string { return "fake" }
+
+func (s *fakeStrategy) Reload(ctx context.Context, checks []string) (string, error) {
+	s.reloads = append(s.reloads, checks)
+	return "fallback", s.err
+}
+
+func TestIPCStrategy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "auth_token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var paths []string
+	status := http.StatusOK
+
+	agent := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		mutex.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte("ok"))
+	}))
+	defer agent.Close()
+
+	tests := []struct {
+		name      string
+		tokenFile string
+		status    int
+		checks    []string
+		paths     []string
+		fallback  bool
+	}{
+		{"changed checks", tokenFile, http.StatusOK, []string{"redisdb", "tcp_check"}, []string{"POST /check/redisdb/reload", "POST /check/tcp_check/reload"}, false},
+		{"not implemented", tokenFile, http.StatusNotImplemented, []string{"redisdb"}, []string{"POST /check/redisdb/reload"}, true},
+		{"missing token", filepath.Join(dir, "missing"), http.StatusOK, []string{"redisdb"}, nil, true},
+		{"no checks", tokenFile, http.StatusOK, []string{}, nil, true},
+	}
+
+	for _, test := range tests {
+		paths = nil
+		status = test.status
+
+		fallback := &fakeStrategy{}
+		strategy := newIPCStrategy(cfg.IPCSettings{
+			URL:           agent.URL + "/check/{check}/reload",
+			AuthTokenFile: test.tokenFile,
+		}, fallback)
+
+		output, err := strategy.Reload(context.Background(), test.checks)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s: requests = %v, want %v", test.name, paths, test.paths)
+		}
+
+		if got := len(fallback.reloads) == 1; got != test.fallback {
+			t.Errorf("%s: fallback reloads = %v, want fallback %v", test.name, fallback.reloads, test.fallback)
+		}
+
+		if test.fallback && output != "fallback" {
+			t.Errorf("%s: output = %q, want the output of the fallback", test.name, output)
+		}
+	}
+}
@@ -1,80 +1,277 @@
 package reloader
 
 import (
-	"expvar"
-	"os"
-	"os/exec"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/dogstatsd"
+	"github.com/seatgeek/datadog-service-helper/metrics"
 	"github.com/sirupsen/logrus"
 )
 
 type Reloader struct {
 	payload      *cfg.ServicePayload
 	shouldReload bool
-	mutex        sync.Mutex
+	pending      map[string]*cfg.ReloadPayload
+	history      *history
+	nextAttempt  time.Time
+	lastChange   time.Time
+	// firstChange is the first change since the last reload, for reload.max_wait
+	firstChange time.Time
+	lastReload  time.Time
+	// recent are the successful reloads of the last hour, for reload.max_per_hour
+	recent     []time.Time
+	suppressed bool
+	backoff    *cfg.Backoff
+	strategy   Strategy
+	mutex      sync.Mutex
+	// now is time.Now, replaced in tests
+	now func() time.Time
 }
 
 var logger = logrus.New()
-var reloadCounter = expvar.NewInt("datadog_agent_reloads")
+var reloadCounter = metrics.NewExpvarInt("datadog_agent_reloads", metrics.Rate)
+var reloadErrorCounter = metrics.NewExpvarInt("datadog_agent_reload_errors", metrics.Rate)
+var reloadSuppressedCounter = metrics.NewExpvarInt("datadog_agent_reloads_suppressed", metrics.Rate)
+var reloads = metrics.NewCounterVec("reloads_total", "Reloads of the datadog-agent by strategy and result.", "strategy", "result")
+var reloadDuration = metrics.NewHistogramVec("reload_duration_seconds", "Duration of datadog-agent reloads.",
+	[]float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60}, "strategy")
+
+func NewReloader(payload *cfg.ServicePayload) (*Reloader, error) {
+	strategy, err := NewStrategy(payload.Settings.Reload)
+	if err != nil {
+		return nil, err
+	}
 
-func NewReloader(payload *cfg.ServicePayload) *Reloader {
 	return &Reloader{
 		payload:      payload,
 		shouldReload: false,
-	}
+		pending:      make(map[string]*cfg.ReloadPayload),
+		history:      newHistory(payload.Settings.Reload.HistorySize),
+		backoff:      payload.Settings.Retry.Backoff(),
+		strategy:     strategy,
+		now:          time.Now,
+	}, nil
 }
 
 func (r *Reloader) Start() {
-	timer := time.NewTicker(5 * time.Second)
+	timer := time.NewTicker(r.payload.Settings.Reload.Interval)
 
 	for {
 		select {
 		case <-r.payload.QuitCh:
 			return
 
-		case <-r.payload.ReloadCh:
-			r.mutex.Lock()
+		case payload := <-r.payload.ReloadCh:
+			r.changed(payload)
 
-			logger.Info("Marking datadog-agent for reloading")
-			r.shouldReload = true
+		case <-timer.C:
+			r.tick()
+		}
+	}
+}
 
-			r.mutex.Unlock()
+// changed marks the agent for reloading after a backend changed its files
+func (r *Reloader) changed(payload cfg.ReloadPayload) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-		case <-timer.C:
-			r.mutex.Lock()
+	logger.Infof("Marking datadog-agent for reloading (%s changed)", payload.Service)
+	if !r.shouldReload {
+		r.firstChange = r.now()
+	}
 
-			logger.Debugf("Reloader ticker start")
+	r.shouldReload = true
+	r.markPending(payload)
+	r.lastChange = r.now()
+}
+
+// tick reloads the agent if it is marked for reloading and the limits allow it
+func (r *Reloader) tick() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	logger.Debugf("Reloader ticker start")
+	defer logger.Debugf("Reloader ticker stop")
+
+	if !r.shouldReload || !r.readyToReload(r.now()) {
+		return
+	}
 
-			if r.shouldReload {
-				r.reloadDataDogService()
-				r.shouldReload = false
-			}
+	r.lastReload = r.now()
 
-			logger.Debugf("Reloader ticker stop")
+	if err := r.reload(); err != nil {
+		// keep shouldReload set, so the reload is retried after the backoff
+		reloadErrorCounter.Add(1)
+		delay := r.backoff.Next()
+		r.nextAttempt = r.now().Add(delay)
+		logger.Errorf("Failed to reload datadog-agent (retrying in %s): %s", delay, err)
+		return
+	}
 
-			r.mutex.Unlock()
+	// only needed (and trimmed) for the hourly cap, failed attempts are held back by the backoff
+	if r.payload.Settings.Reload.MaxPerHour > 0 {
+		r.recent = append(r.recent, r.lastReload)
+	}
+
+	r.backoff.Reset()
+	r.shouldReload = false
+	r.pending = make(map[string]*cfg.ReloadPayload)
+}
+
+// readyToReload applies the retry backoff, debounce, minimum interval and hourly cap
+func (r *Reloader) readyToReload(now time.Time) bool {
+	settings := r.payload.Settings.Reload
+
+	if now.Before(r.nextAttempt) {
+		return false
+	}
+
+	// wait for things to settle down, e.g. during a rolling deploy, but no longer than max_wait
+	if now.Sub(r.lastChange) < settings.Debounce && (settings.MaxWait <= 0 || now.Sub(r.firstChange) < settings.MaxWait) {
+		return false
+	}
+
+	if now.Sub(r.lastReload) < settings.MinInterval {
+		return false
+	}
+
+	if settings.MaxPerHour <= 0 {
+		return true
+	}
+
+	// forget reloads older than an hour
+	hourAgo := now.Add(-time.Hour)
+	for len(r.recent) > 0 && r.recent[0].Before(hourAgo) {
+		r.recent = r.recent[1:]
+	}
+
+	if len(r.recent) >= settings.MaxPerHour {
+		// only count and warn once for every reload we hold back
+		if !r.suppressed {
+			r.suppressed = true
+			reloadSuppressedCounter.Add(1)
+			logger.Warnf("Not reloading datadog-agent, already reloaded %d times in the last hour (reload.max_per_hour), next reload at %s",
+				len(r.recent), r.recent[0].Add(time.Hour).Format(time.RFC3339))
 		}
+		return false
+	}
+
+	r.suppressed = false
+	return true
+}
+
+// markPending remembers what changed since the last reload, keeping the oldest
+// "old" hash so the history shows the full change of every backend
+func (r *Reloader) markPending(payload cfg.ReloadPayload) {
+	if existing, found := r.pending[payload.Service]; found {
+		payload.OldHash = existing.OldHash
 	}
+
+	r.pending[payload.Service] = &payload
 }
 
-func (r *Reloader) reloadDataDogService() {
-	reloadCounter.Add(1)
+// reload the agent and record the attempt in the history
+func (r *Reloader) reload() error {
+	entry := &HistoryEntry{
+		Time:     time.Now(),
+		Strategy: r.strategy.Name(),
+		Changes:  make([]cfg.ReloadPayload, 0, len(r.pending)),
+	}
+
+	services := make([]string, 0, len(r.pending))
+	for service := range r.pending {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		entry.Changes = append(entry.Changes, *r.pending[service])
+	}
+
+	output, err := r.reloadDataDogService(entry.checks())
+
+	reloadDuration.Since(entry.Time, entry.Strategy)
+	if err != nil {
+		reloads.Inc(entry.Strategy, "failure")
+	} else {
+		reloads.Inc(entry.Strategy, "success")
+	}
+
+	entry.Duration = time.Since(entry.Time).String()
+	entry.Success = err == nil
+	entry.Output = output
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	r.history.add(entry)
+	r.announce(entry)
+	return err
+}
 
-	if os.Getenv("DONT_RELOAD_DATADOG") != "" {
-		logger.Infof("Not reloading datadog-agent (env: DONT_RELOAD_DATADOG)")
+// announce the reload and the backends that triggered it in the DataDog event stream
+func (r *Reloader) announce(entry *HistoryEntry) {
+	if _, ok := r.strategy.(*noopStrategy); ok {
 		return
 	}
 
-	logger.Warn("Reloading datadog-agent")
+	backends := make([]string, 0, len(entry.Changes))
+	tags := []string{"strategy:" + entry.Strategy}
+	for _, change := range entry.Changes {
+		backends = append(backends, change.Service)
+		tags = append(tags, "backend:"+change.Service)
+	}
+
+	event := &dogstatsd.Event{
+		Title:     "datadog-agent reloaded on " + r.payload.NodeName,
+		Text:      fmt.Sprintf("Triggered by changes in %s (strategy: %s, took %s)", strings.Join(backends, ", "), entry.Strategy, entry.Duration),
+		AlertType: dogstatsd.AlertSuccess,
+		Tags:      tags,
+	}
+
+	result := "success"
+	if !entry.Success {
+		result = "failure"
+		event.Title = "datadog-agent reload failed on " + r.payload.NodeName
+		event.Text += "\n" + entry.Error
+		event.AlertType = dogstatsd.AlertError
+	}
+
+	r.payload.StatsD.Count("agent.reloads", 1, "strategy:"+entry.Strategy, "result:"+result)
+	r.payload.StatsD.Event(event)
+}
+
+func (r *Reloader) reloadDataDogService(checks []string) (string, error) {
+	reloadCounter.Add(1)
+
+	if _, ok := r.strategy.(*noopStrategy); ok {
+		logger.Infof("Not reloading datadog-agent (reload.disabled or env: DONT_RELOAD_DATADOG)")
+		return "", nil
+	}
+
+	logger.Warnf("Reloading datadog-agent (strategy: %s)", r.strategy.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.payload.Settings.Reload.Timeout)
+	defer cancel()
+
+	output, err := r.strategy.Reload(ctx, checks)
+	if err != nil {
+		if output != "" {
+			return output, fmt.Errorf("%s (output: %s)", err, output)
+		}
+		return output, err
+	}
 
-	cmd := "/usr/sbin/service"
-	args := []string{"datadog-agent", "reload"}
-	if err := exec.Command(cmd, args...).Run(); err != nil {
-		logger.Fatalf("Failed to reload datadog-agent: %s", err)
+	if output != "" {
+		logger.Infof("Reload output: %s", output)
 	}
 
 	logger.Infof("Successfully reloaded datadog-agent")
+	return output, nil
 }
@@ -0,0 +1,146 @@
+package reloader
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// step is a change of a backend, or a tick of the reload ticker, at a time after the start
+type step struct {
+	at     time.Duration
+	change bool
+	// fail makes the reload fail on this tick
+	fail bool
+	// reloads is the number of reload attempts expected after the step
+	reloads int
+}
+
+func TestReloadLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings func(s *cfg.ReloadSettings)
+		steps    []step
+	}{
+		{
+			name:     "no limits",
+			settings: func(s *cfg.ReloadSettings) {},
+			steps: []step{
+				{at: 0, reloads: 0},
+				{at: 1 * time.Second, change: true, reloads: 0},
+				{at: 2 * time.Second, reloads: 1},
+				{at: 3 * time.Second, reloads: 1},
+			},
+		},
+		{
+			name:     "debounce",
+			settings: func(s *cfg.ReloadSettings) { s.Debounce = 30 * time.Second },
+			steps: []step{
+				{at: 0, change: true},
+				{at: 20 * time.Second, change: true},
+				{at: 40 * time.Second, reloads: 0},
+				{at: 49 * time.Second, reloads: 0},
+				{at: 50 * time.Second, reloads: 1},
+			},
+		},
+		{
+			name: "debounce bounded by max_wait",
+			settings: func(s *cfg.ReloadSettings) {
+				s.Debounce = 30 * time.Second
+				s.MaxWait = time.Minute
+			},
+			steps: []step{
+				{at: 0, change: true},
+				{at: 20 * time.Second, change: true},
+				{at: 40 * time.Second, change: true},
+				{at: 50 * time.Second, reloads: 0},
+				{at: 55 * time.Second, change: true},
+				{at: 60 * time.Second, reloads: 1},
+				// max_wait starts over with the next change
+				{at: 70 * time.Second, change: true},
+				{at: 80 * time.Second, change: true},
+				{at: 90 * time.Second, reloads: 1},
+				{at: 110 * time.Second, reloads: 2},
+			},
+		},
+		{
+			name:     "min_interval",
+			settings: func(s *cfg.ReloadSettings) { s.MinInterval = time.Minute },
+			steps: []step{
+				{at: 0, change: true},
+				{at: 1 * time.Second, reloads: 1},
+				{at: 2 * time.Second, change: true},
+				{at: 30 * time.Second, reloads: 1},
+				{at: 60 * time.Second, reloads: 1},
+				{at: 61 * time.Second, reloads: 2},
+			},
+		},
+		{
+			name:     "hourly cap",
+			settings: func(s *cfg.ReloadSettings) { s.MaxPerHour = 2 },
+			steps: []step{
+				{at: 0, change: true},
+				{at: 1 * time.Second, reloads: 1},
+				{at: 10 * time.Minute, change: true},
+				{at: 11 * time.Minute, reloads: 2},
+				{at: 20 * time.Minute, change: true},
+				{at: 21 * time.Minute, reloads: 2},
+				{at: 60 * time.Minute, reloads: 2},
+				{at: 61 * time.Minute, reloads: 3},
+			},
+		},
+		{
+			name:     "hourly cap ignores failed reloads",
+			settings: func(s *cfg.ReloadSettings) { s.MaxPerHour = 1 },
+			steps: []step{
+				{at: 0, change: true},
+				{at: 1 * time.Second, fail: true, reloads: 1},
+				{at: 10 * time.Minute, fail: true, reloads: 2},
+				{at: 20 * time.Minute, reloads: 3},
+				{at: 30 * time.Minute, change: true},
+				{at: 31 * time.Minute, reloads: 3},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		settings := cfg.DefaultSettings()
+		settings.Reload.Strategy = cfg.ReloadNone
+		settings.Reload.MinInterval = 0
+		settings.Reload.MaxWait = 0
+		test.settings(&settings.Reload)
+
+		r, err := NewReloader(&cfg.ServicePayload{Settings: settings})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		strategy := &fakeStrategy{}
+		r.strategy = strategy
+
+		start := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+		var now time.Time
+		r.now = func() time.Time { return now }
+
+		for _, s := range test.steps {
+			now = start.Add(s.at)
+
+			strategy.err = nil
+			if s.fail {
+				strategy.err = errors.New("reload failed")
+			}
+
+			if s.change {
+				r.changed(cfg.ReloadPayload{Service: "tcp-check", Check: "tcp_check"})
+				continue
+			}
+
+			r.tick()
+			if got := len(strategy.reloads); got != s.reloads {
+				t.Errorf("%s: %d reloads after %s, want %d", test.name, got, s.at, s.reloads)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package reloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals that can be used by the "signal" reload strategy
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
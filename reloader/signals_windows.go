@@ -0,0 +1,14 @@
+package reloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals that can be used by the "signal" reload strategy
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
@@ -0,0 +1,149 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// Strategy reloads the datadog-agent so it picks up changed config files
+type Strategy interface {
+	// Name of the strategy, used for logging
+	Name() string
+
+	// Reload the agent after the config of checks changed,
+	// output is whatever the reload printed (if anything)
+	Reload(ctx context.Context, checks []string) (output string, err error)
+}
+
+// NewStrategy creates the reload strategy configured in settings
+func NewStrategy(settings cfg.ReloadSettings) (Strategy, error) {
+	switch settings.Strategy {
+	case cfg.ReloadService:
+		return &commandStrategy{name: "service", command: []string{"/usr/sbin/service", settings.Unit, "reload"}}, nil
+
+	case cfg.ReloadSystemd:
+		// the datadog-agent v6+ unit has no ExecReload and gets restarted, units that can reload are reloaded
+		return &commandStrategy{name: "systemd", command: []string{"systemctl", "reload-or-restart", settings.Unit}}, nil
+
+	case cfg.ReloadCommand:
+		return &commandStrategy{name: "command", command: settings.Command}, nil
+
+	case cfg.ReloadSignal:
+		signal, found := signals[strings.TrimPrefix(strings.ToUpper(settings.Signal), "SIG")]
+		if !found {
+			return nil, fmt.Errorf("Unknown reload.signal %q", settings.Signal)
+		}
+		return &signalStrategy{pidFile: settings.PidFile, signal: signal}, nil
+
+	case cfg.ReloadMarker:
+		return &markerStrategy{markerFile: settings.MarkerFile}, nil
+
+	case cfg.ReloadIPC:
+		fallback := settings
+		fallback.Strategy = settings.IPC.Fallback
+
+		strategy, err := NewStrategy(fallback)
+		if err != nil {
+			return nil, err
+		}
+
+		return newIPCStrategy(settings.IPC, strategy), nil
+
+	case cfg.ReloadNone:
+		return &noopStrategy{}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown reload.strategy %q", settings.Strategy)
+	}
+}
+
+// commandStrategy runs a command, e.g. "service datadog-agent reload"
+type commandStrategy struct {
+	name    string
+	command []string
+}
+
+func (s *commandStrategy) Name() string { return s.name }
+
+func (s *commandStrategy) Reload(ctx context.Context, checks []string) (string, error) {
+	var output bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%s timed out", strings.Join(s.command, " "))
+	} else if err != nil {
+		err = fmt.Errorf("%s failed: %s", strings.Join(s.command, " "), err)
+	}
+
+	return strings.TrimSpace(output.String()), err
+}
+
+// signalStrategy sends a signal to the process in a pid file
+type signalStrategy struct {
+	pidFile string
+	signal  os.Signal
+}
+
+func (s *signalStrategy) Name() string { return "signal" }
+
+func (s *signalStrategy) Reload(ctx context.Context, checks []string) (string, error) {
+	data, err := ioutil.ReadFile(s.pidFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not read pid file: %s", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("Invalid pid in %s: %s", s.pidFile, err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return "", err
+	}
+
+	if err := process.Signal(s.signal); err != nil {
+		return "", fmt.Errorf("Could not send %s to pid %d: %s", s.signal, pid, err)
+	}
+
+	return fmt.Sprintf("sent %s to pid %d", s.signal, pid), nil
+}
+
+// markerStrategy writes the current time to a file, for setups where something else
+// (e.g. a sidecar or the container entrypoint) watches it and reloads the agent
+type markerStrategy struct {
+	markerFile string
+}
+
+func (s *markerStrategy) Name() string { return "marker" }
+
+func (s *markerStrategy) Reload(ctx context.Context, checks []string) (string, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := ioutil.WriteFile(s.markerFile, []byte(now+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("Could not write marker file: %s", err)
+	}
+
+	return fmt.Sprintf("wrote %s to %s", now, s.markerFile), nil
+}
+
+// noopStrategy never reloads, e.g. for local development
+type noopStrategy struct{}
+
+func (s *noopStrategy) Name() string { return "none" }
+
+func (s *noopStrategy) Reload(ctx context.Context, checks []string) (string, error) {
+	return "", nil
+}
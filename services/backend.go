@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// Backend is a DataDog integration that can be configured from Consul services
+type Backend interface {
+	// Name of the backend, used for logging and reload payloads
+	Name() string
+
+	// TagSuffix is the part after "dd-" in the Consul service tag that enables the backend
+	TagSuffix() string
+
+	// CheckName is the DataDog check the backend configures, e.g. "tcp_check"
+	CheckName() string
+
+	// DefaultPath is the dd-agent (v5) config file written when no override is provided
+	DefaultPath() string
+
+	// BuildInstance creates the check instance for a Consul service, params holds
+	// the per-service parameters from the service Meta and tags.
+	// Returning a nil Instance without an error skips the service.
+	BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (Instance, error)
+}
+
+// Instance is a single entry in the "instances" list of a dd-agent config file
+type Instance interface {
+	// SortKey is used to order instances so we get consistent output across runs
+	SortKey() string
+}
+
+// Config is the dd-agent config file written by all backends
+type Config struct {
+	InitConfig []string      `yaml:"init_config,flow"`
+	Instances  []interface{} `yaml:"instances"`
+}
+
+var (
+	registry      = make(map[string]Backend)
+	registryMutex sync.Mutex
+)
+
+// Register makes a backend available to the daemon, it panics if the name is already taken
+func Register(backend Backend) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	name := backend.Name()
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("services: backend %s is already registered", name))
+	}
+
+	registry[name] = backend
+}
+
+// Names returns the names of all registered backends sorted by name
+func Names() []string {
+	names := make([]string, 0)
+	for _, backend := range Backends() {
+		names = append(names, backend.Name())
+	}
+
+	return names
+}
+
+// Backends returns all registered backends sorted by name
+func Backends() []Backend {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	list := make([]Backend, 0, len(registry))
+	for _, backend := range registry {
+		list = append(list, backend)
+	}
+
+	sort.Sort(backendSorter(list))
+	return list
+}
+
+// pathEnv is the environment variable that overrides the backend file path,
+// e.g. "PHP_FPM_CONFIG_FILE" for the "php-fpm" suffix
+func pathEnv(backend Backend) string {
+	return strings.ToUpper(strings.Replace(backend.TagSuffix(), "-", "_", -1)) + "_CONFIG_FILE"
+}
+
+// backendSorter sorts backends by name
+type backendSorter []Backend
+
+func (a backendSorter) Len() int           { return len(a) }
+func (a backendSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a backendSorter) Less(i, j int) bool { return a[i].Name() < a[j].Name() }
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// recentErrorsSize is the number of errors kept for the dashboard
+const recentErrorsSize = 50
+
+// RecentError is a failure of a backend or of the Consul watch
+type RecentError struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+// errorLog is a ring buffer of the most recent errors
+type errorLog struct {
+	entries []*RecentError
+	next    int
+	size    int
+	mutex   sync.RWMutex
+}
+
+var recentErrors = newErrorLog(recentErrorsSize)
+
+func newErrorLog(size int) *errorLog {
+	return &errorLog{entries: make([]*RecentError, size), size: size}
+}
+
+func (l *errorLog) add(source string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries[l.next] = &RecentError{Time: time.Now(), Source: source, Message: err.Error()}
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns the recorded errors, newest first
+func (l *errorLog) list() []*RecentError {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	list := make([]*RecentError, 0, l.size)
+	for i := 1; i <= l.size; i++ {
+		if entry := l.entries[(l.next-i+l.size)%l.size]; entry != nil {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// RecordError remembers an error of source (a backend name, or "consul") for the dashboard
+func RecordError(source string, err error) {
+	recentErrors.add(source, err)
+}
+
+// RecentErrors returns the most recent errors, newest first
+func RecentErrors() []*RecentError {
+	return recentErrors.list()
+}
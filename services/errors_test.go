@@ -0,0 +1,29 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorLog(t *testing.T) {
+	log := newErrorLog(3)
+
+	if got := log.list(); len(got) != 0 {
+		t.Errorf("list() of an empty log = %v", got)
+	}
+
+	for i := 1; i <= 5; i++ {
+		log.add("consul", fmt.Errorf("error %d", i))
+	}
+
+	list := log.list()
+	if len(list) != 3 {
+		t.Fatalf("list() has %d errors, want 3", len(list))
+	}
+
+	for i, want := range []string{"error 5", "error 4", "error 3"} {
+		if list[i].Message != want || list[i].Source != "consul" {
+			t.Errorf("list()[%d] = %s: %s, want consul: %s", i, list[i].Source, list[i].Message, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/dogstatsd"
+)
+
+// announce sends DogStatsD events and counts for the services that started or
+// stopped being monitored since the previous sync
+func (r *runner) announce(entries []*entry) {
+	current := make(map[string]*cfg.Service)
+	for _, e := range entries {
+		current[e.service.ID] = e.service
+	}
+
+	previous := r.known
+	r.known = current
+
+	// the first sync after a (re)start would announce everything that was already monitored
+	if previous == nil {
+		return
+	}
+
+	for _, id := range sortedIDs(current) {
+		if _, found := previous[id]; !found {
+			r.event("added", current[id])
+		}
+	}
+
+	for _, id := range sortedIDs(previous) {
+		if _, found := current[id]; !found {
+			r.event("removed", previous[id])
+		}
+	}
+}
+
+func (r *runner) event(action string, service *cfg.Service) {
+	tags := []string{"backend:" + r.name, "check:" + r.backend.CheckName(), "service:" + service.Service}
+
+	r.payload.StatsD.Count("instances."+action, 1, tags...)
+	r.payload.StatsD.Event(&dogstatsd.Event{
+		Title: fmt.Sprintf("Monitoring %s: %s (%s)", action, service.Service, r.name),
+		Text: fmt.Sprintf("%s check %s for service %s (%s) at %s:%d on %s",
+			r.backend.CheckName(), action, service.Service, service.ID, service.Address, service.Port, r.payload.NodeName),
+		AlertType: dogstatsd.AlertInfo,
+		Tags:      tags,
+	})
+}
+
+func sortedIDs(services map[string]*cfg.Service) []string {
+	ids := make([]string, 0, len(services))
+	for id := range services {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
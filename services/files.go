@@ -0,0 +1,182 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// perServicePrefix marks the files we own in a <check>.d directory, so we never
+// remove configuration written by someone else
+const perServicePrefix = "service-helper-"
+
+// singleFileName is the file written in a <check>.d directory when all services share
+// one file, the conf.yaml next to it stays with whoever configured the check by hand
+const singleFileName = "service-helper.yaml"
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// fileSet decides which file(s) a backend writes and remembers their hashes
+type fileSet struct {
+	name string
+	// filePath is the single file written by the backend, empty in per-service mode
+	filePath string
+	// dir is the <check>.d directory holding one file per service
+	dir string
+	// createDirs is set for the v6 layout, where the <check>.d directory might not exist yet
+	createDirs bool
+	hashes     map[string]string
+	// rejected is the hash of the content the validation command failed on, per file
+	rejected map[string]string
+}
+
+func newFileSet(backend Backend, settings *cfg.BackendSettings, agent cfg.AgentSettings) *fileSet {
+	f := &fileSet{
+		name:       backend.Name(),
+		createDirs: agent.Layout == cfg.LayoutV6,
+		hashes:     make(map[string]string),
+		rejected:   make(map[string]string),
+	}
+
+	checkDir := filepath.Join(agent.ConfDir, backend.CheckName()+".d")
+
+	if agent.FilePerService {
+		f.dir = checkDir
+		return f
+	}
+
+	f.filePath = os.Getenv(pathEnv(backend))
+	if f.filePath == "" {
+		f.filePath = settings.Path
+	}
+	if f.filePath == "" && agent.Layout == cfg.LayoutV6 {
+		f.filePath = filepath.Join(checkDir, singleFileName)
+	}
+	if f.filePath == "" {
+		f.filePath = backend.DefaultPath()
+	}
+
+	return f
+}
+
+// loadHashes hashes the files already on disk, so we don't reload the agent on startup
+func (f *fileSet) loadHashes() {
+	files := []string{f.filePath}
+	if f.filePath == "" {
+		files, _ = filepath.Glob(filepath.Join(f.dir, perServicePrefix+"*.yaml"))
+	}
+
+	for _, filePath := range files {
+		currentHash, err := cfg.HashFileMd5(filePath)
+		if err != nil {
+			logger.Warnf("[%s] Could not get initial hash for %s: %s", f.name, filePath, err)
+			currentHash = ""
+		}
+
+		logger.Infof("[%s] Existing file hash %s: %s", f.name, filePath, currentHash)
+		f.hashes[filePath] = currentHash
+	}
+}
+
+// prepare makes sure the directory for filePath exists
+func (f *fileSet) prepare(filePath string) error {
+	if !f.createDirs {
+		return nil
+	}
+
+	return os.MkdirAll(filepath.Dir(filePath), 0755)
+}
+
+// paths returns the files that should exist for the entries
+func (f *fileSet) paths(entries []*entry) []string {
+	if f.filePath != "" {
+		return []string{f.filePath}
+	}
+
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		filePath := f.servicePath(e.service)
+		if !seen[filePath] {
+			seen[filePath] = true
+			paths = append(paths, filePath)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// entriesFor returns the entries to be written to filePath
+func (f *fileSet) entriesFor(filePath string, entries []*entry) []*entry {
+	if f.filePath != "" {
+		return entries
+	}
+
+	list := make([]*entry, 0)
+	for _, e := range entries {
+		if f.servicePath(e.service) == filePath {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
+
+// removeStale removes the per-service files of services that are gone
+func (f *fileSet) removeStale(entries []*entry) (bool, error) {
+	if f.filePath != "" {
+		return false, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, filePath := range f.paths(entries) {
+		wanted[filePath] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(f.dir, perServicePrefix+"*.yaml"))
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	for _, filePath := range files {
+		if wanted[filePath] {
+			continue
+		}
+
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+
+		logger.Infof("[%s] Removed file for stopped service: %s", f.name, filePath)
+		delete(f.hashes, filePath)
+		removed = true
+	}
+
+	return removed, nil
+}
+
+// servicePath is the per-service file for a service, based on its (unique) ID
+func (f *fileSet) servicePath(service *cfg.Service) string {
+	return filepath.Join(f.dir, perServicePrefix+unsafeFileChars.ReplaceAllString(service.ID, "_")+".yaml")
+}
+
+// hash summarises all files, so the reloader can tell what changed
+func (f *fileSet) hash() string {
+	if f.filePath != "" {
+		return f.hashes[f.filePath]
+	}
+
+	lines := make([]string, 0, len(f.hashes))
+	for filePath, hash := range f.hashes {
+		lines = append(lines, filePath+":"+hash)
+	}
+	sort.Strings(lines)
+
+	return cfg.HashBytes([]byte(strings.Join(lines, "\n")))
+}
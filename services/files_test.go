@@ -0,0 +1,144 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// testBackend is a backend without instances, enough for the file handling
+type testBackend struct {
+	check string
+}
+
+func (b *testBackend) Name() string        { return "test-" + b.check }
+func (b *testBackend) TagSuffix() string   { return "test-" + b.check }
+func (b *testBackend) CheckName() string   { return b.check }
+func (b *testBackend) DefaultPath() string { return "/etc/dd-agent/conf.d/" + b.check + ".yaml" }
+
+func (b *testBackend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (Instance, error) {
+	return nil, nil
+}
+
+// writeFiles creates every file with some content, relative to dir
+func writeFiles(t *testing.T, dir string, files ...string) {
+	for _, file := range files {
+		filePath := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filePath, []byte("instances: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles returns all files below dir, relative to it
+func listFiles(t *testing.T, dir string) []string {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		files = append(files, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+func testEntry(id string) *entry {
+	return &entry{service: &cfg.Service{AgentService: &consul.AgentService{ID: id, Service: id}}}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir,
+		"tcp_check.d/conf.yaml",
+		"tcp_check.d/service-helper.yaml",
+		"tcp_check.d/service-helper-web-1.yaml",
+		"tcp_check.d/service-helper-web-2.yaml",
+		"tcp_check.d/service-helper-web-2.yaml.example",
+		"tcp_check.d/web-3.yaml",
+		"redisdb.d/service-helper-web-2.yaml",
+	)
+
+	agent := cfg.AgentSettings{Layout: cfg.LayoutV6, ConfDir: dir, FilePerService: true}
+	files := newFileSet(&testBackend{check: "tcp_check"}, &cfg.BackendSettings{}, agent)
+
+	removed, err := files.removeStale([]*entry{testEntry("web-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !removed {
+		t.Error("removeStale should report the removed file")
+	}
+
+	want := []string{
+		"redisdb.d/service-helper-web-2.yaml",
+		"tcp_check.d/conf.yaml",
+		"tcp_check.d/service-helper-web-1.yaml",
+		"tcp_check.d/service-helper-web-2.yaml.example",
+		"tcp_check.d/service-helper.yaml",
+		"tcp_check.d/web-3.yaml",
+	}
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after removeStale = %v, want %v", got, want)
+	}
+
+	// nothing left to remove
+	removed, err = files.removeStale([]*entry{testEntry("web-1")})
+	if err != nil || removed {
+		t.Errorf("second removeStale = %v, %v, want false, nil", removed, err)
+	}
+}
+
+func TestRemoveStaleSingleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := []string{
+		"tcp_check.d/conf.yaml",
+		"tcp_check.d/service-helper-web-1.yaml",
+		"tcp_check.d/service-helper.yaml",
+	}
+	writeFiles(t, dir, want...)
+
+	agent := cfg.AgentSettings{Layout: cfg.LayoutV6, ConfDir: dir}
+	files := newFileSet(&testBackend{check: "tcp_check"}, &cfg.BackendSettings{}, agent)
+
+	if files.filePath != filepath.Join(dir, "tcp_check.d", singleFileName) {
+		t.Errorf("filePath = %s, want the service-helper.yaml in tcp_check.d", files.filePath)
+	}
+
+	removed, err := files.removeStale(nil)
+	if err != nil || removed {
+		t.Errorf("removeStale = %v, %v, want false, nil", removed, err)
+	}
+
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after removeStale = %v, want %v", got, want)
+	}
+}
@@ -4,102 +4,75 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"sort"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
 	cache "github.com/patrickmn/go-cache"
 	cfg "github.com/seatgeek/datadog-service-helper/config"
-	"github.com/sirupsen/logrus"
+	"github.com/seatgeek/datadog-service-helper/metrics"
+	"github.com/seatgeek/datadog-service-helper/services"
 	yaml "gopkg.in/yaml.v2"
 )
 
 var configCache = cache.New(30*time.Minute, 30*time.Second)
-var logger = logrus.New()
+var configFetches = metrics.NewCounterVec("goexpvar_config_fetches_total", "Fetches of remote go-expvar configs by result (cached, success or failure).", "result")
+var configFetchErrors = metrics.NewExpvarInt("go_expvar_config_fetch_errors", metrics.Rate)
+var configFetchDuration = metrics.NewHistogramVec("goexpvar_config_fetch_duration_seconds", "Duration of remote go-expvar config fetches.", metrics.DefaultBuckets)
 
-// Observe changes in Consul catalog for go-expvar
-func Observe(payload *cfg.ServicePayload) {
-	filePath := os.Getenv("GO_EXPVAR_CONFIG_FILE")
-	if filePath == "" {
-		filePath = "/etc/dd-agent/conf.d/go_expvar.yaml"
-	}
-
-	currentHash, err := cfg.HashFileMd5(filePath)
-	if err != nil {
-		logger.Warnf("[go-expvar] Could not get initial hash for %s: %s", filePath, err)
-		currentHash = ""
-	}
-
-	logger.Infof("[go-expvar] Existing file hash %s: %s", filePath, currentHash)
-
-	stream := payload.Services.Observe()
-
-	for {
-		select {
-		case <-payload.QuitCh:
-			logger.Warn("[go-expvar] stopping")
-			return
-
-		case <-stream.Changes():
-			stream.Next()
-
-			t := &Config{}
-
-			services := stream.Value().(map[string]*consul.AgentService)
-
-			for _, service := range services {
-				if !cfg.ServiceEnabled("go-expvar", service.Tags) {
-					logger.Debugf("[go-expvar] Service %s does not contain 'dd-go-expvar' tag", service.Service)
-					continue
-				}
-				logger.Infof("[go-expvar] Service %s tags does contain 'dd-go-expvar'", service.Service)
+func init() {
+	services.Register(&Backend{})
+}
 
-				url := fmt.Sprintf("http://%s:%d/datadog/expvar", service.Address, service.Port)
+// Backend monitors Consul catalog for go-expvar services
+type Backend struct{}
 
-				check, err := getRemoteConfig(url)
-				if err != nil {
-					logger.Warnf("[go-expvar] Could not get remote config for %s: %s", url, err)
-					continue
-				}
+// Name ...
+func (b *Backend) Name() string { return "go-expvar" }
 
-				if check.ExpvarURL != "" {
-					t.Instances = append(t.Instances, check)
-				}
-			}
+// TagSuffix ...
+func (b *Backend) TagSuffix() string { return "go-expvar" }
 
-			// Sort the services by name so we get consistent output across runs
-			sort.Sort(ServiceSorter(t.Instances))
+// CheckName ...
+func (b *Backend) CheckName() string { return "go_expvar" }
 
-			data, err := yaml.Marshal(&t)
-			if err != nil {
-				logger.Fatalf("[go-expvar] could not marshal yaml: %v", err)
-			}
+// DefaultPath ...
+func (b *Backend) DefaultPath() string { return "/etc/dd-agent/conf.d/go_expvar.yaml" }
 
-			reloadRequired, newHash := cfg.WriteIfChange("go-expvar", filePath, data, currentHash)
-			if !reloadRequired {
-				currentHash = newHash
-				continue
-			}
+// BuildInstance fetches the expvar check config the service exposes itself
+func (b *Backend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (services.Instance, error) {
+	url := fmt.Sprintf("http://%s:%d/datadog/expvar", service.Address, service.Port)
 
-			payload.ReloadCh <- cfg.ReloadPayload{
-				Service: "go-expvar",
-				OldHash: currentHash,
-				NewHash: newHash,
-			}
+	check, err := getRemoteConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get remote config for %s: %s", url, err)
+	}
 
-			currentHash = newHash
-		}
+	if check.ExpvarURL == "" {
+		return nil, nil
 	}
+
+	return check, nil
 }
 
 func getRemoteConfig(url string) (config *ConfigItem, err error) {
 	cached, found := configCache.Get(url)
 	if found {
+		configFetches.Inc("cached")
 		config = cached.(*ConfigItem)
 		return config, nil
 	}
 
+	start := time.Now()
+	defer func() {
+		configFetchDuration.Since(start)
+		if err != nil {
+			configFetches.Inc("failure")
+			configFetchErrors.Add(1)
+		} else {
+			configFetches.Inc("success")
+		}
+	}()
+
 	response, err := http.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("Could not GET url '%s': %s", url, err.Error())
@@ -119,10 +92,3 @@ func getRemoteConfig(url string) (config *ConfigItem, err error) {
 	configCache.Set(url, config, cache.DefaultExpiration)
 	return config, nil
 }
-
-// ServiceSorter sorts planets by ExpvarURL
-type ServiceSorter []*ConfigItem
-
-func (a ServiceSorter) Len() int           { return len(a) }
-func (a ServiceSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ServiceSorter) Less(i, j int) bool { return a[i].ExpvarURL < a[j].ExpvarURL }
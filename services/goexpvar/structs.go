@@ -1,11 +1,5 @@
 package goexpvar
 
-// Config ...
-type Config struct {
-	InitConfig []string      `yaml:"init_config,flow"`
-	Instances  []*ConfigItem `yaml:"instances"`
-}
-
 // ConfigItem ...
 type ConfigItem struct {
 	ExpvarURL string          `yaml:"expvar_url"`
@@ -13,5 +7,8 @@ type ConfigItem struct {
 	Metrics   []*MetricConfig `yaml:"metrics"`
 }
 
+// SortKey sorts instances by ExpvarURL
+func (c *ConfigItem) SortKey() string { return c.ExpvarURL }
+
 // MetricConfig ...
 type MetricConfig map[string]string
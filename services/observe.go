@@ -0,0 +1,331 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/metrics"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var logger = logrus.New()
+var backendErrors = metrics.NewExpvarMap("backend_errors", metrics.Rate)
+var backendInstances = metrics.NewExpvarMap("backend_instances", metrics.Gauge)
+var fileWriteErrors = metrics.NewExpvarMap("file_write_errors", metrics.Rate)
+var servicesDiscovered = metrics.NewGaugeVec("services_discovered", "Services with a generated check, by backend.", "backend")
+var servicesSkipped = metrics.NewGaugeVec("services_skipped", "Services without a generated check, by backend.", "backend")
+var fileWrites = metrics.NewCounterVec("file_writes_total", "Writes of dd-agent config files by backend and result.", "backend", "result")
+
+// runner keeps the dd-agent config file(s) of a single backend up to date
+type runner struct {
+	backend  Backend
+	payload  *cfg.ServicePayload
+	settings *cfg.BackendSettings
+	name     string
+	tag      string
+	files    *fileSet
+	// known are the monitored services as of the last sync, nil before the first one
+	known map[string]*cfg.Service
+	// failed counts the services the backend could not build an instance for in the last sync
+	failed int
+}
+
+// Observe changes in Consul catalog for a backend and keep its dd-agent config file up to date
+func Observe(backend Backend, payload *cfg.ServicePayload) {
+	r := newRunner(backend, payload)
+	r.files.loadHashes()
+
+	stream := payload.Services.Observe()
+	backoff := payload.Settings.Retry.Backoff()
+	var retry <-chan time.Time
+
+	for {
+		select {
+		case <-payload.QuitCh:
+			logger.Warnf("[%s] Stopping", r.name)
+			return
+
+		case <-stream.Changes():
+			stream.Next()
+
+		case <-retry:
+		}
+
+		if err := r.sync(stream.Value().(map[string]*cfg.Service)); err != nil {
+			backendErrors.Add(r.name, 1)
+			r.reportError(err)
+
+			// retry with the latest services, unless Consul sends an update first
+			delay := backoff.Next()
+			logger.Errorf("[%s] %s (retrying in %s)", r.name, err, delay)
+			retry = time.After(delay)
+			continue
+		}
+
+		// e.g. a failed remote config fetch, which may work on the next attempt
+		if r.failed > 0 {
+			delay := backoff.Next()
+			logger.Infof("[%s] Retrying %d skipped services in %s", r.name, r.failed, delay)
+			retry = time.After(delay)
+			continue
+		}
+
+		backoff.Reset()
+		retry = nil
+	}
+}
+
+func newRunner(backend Backend, payload *cfg.ServicePayload) *runner {
+	name := backend.Name()
+	settings := payload.Settings.Backend(name)
+
+	tag := settings.Tag
+	if tag == "" {
+		tag = "dd-" + backend.TagSuffix()
+	}
+
+	// publish all keys up front, so /datadog/expvar lists them before the first error
+	backendErrors.Add(name, 0)
+	fileWriteErrors.Add(name, 0)
+	validationErrors.Add(name, 0)
+	metrics.SetExpvarGauge(backendInstances, name, 0)
+
+	return &runner{
+		backend:  backend,
+		payload:  payload,
+		settings: settings,
+		name:     name,
+		tag:      tag,
+		files:    newFileSet(backend, settings, payload.Settings.Agent),
+	}
+}
+
+// sync renders the config file(s) for the services and asks for a reload if anything changed
+func (r *runner) sync(services map[string]*cfg.Service) error {
+	entries := make([]*entry, 0)
+	skipped := make(map[string]string)
+	r.failed = 0
+
+	for id, service := range services {
+		if !cfg.HasTag(r.tag, service.Tags) {
+			logger.Debugf("[%s] Service %s does not contain '%s' tag", r.name, service.Service, r.tag)
+			skipped[id] = fmt.Sprintf("missing tag %s", r.tag)
+			continue
+		}
+		logger.Infof("[%s] Service %s tags does contain '%s'", r.name, service.Service, r.tag)
+
+		if !r.settings.AllowsHealth(service.Health) {
+			logger.Infof("[%s] Service %s is %s, skipping", r.name, service.Service, service.Health)
+			skipped[id] = fmt.Sprintf("health is %s, allowed: %s", service.Health, strings.Join(r.settings.Health, ", "))
+			continue
+		}
+
+		params := cfg.ServiceParams(r.tag, service.AgentService)
+
+		check, err := r.backend.BuildInstance(r.payload, service.AgentService, params)
+		if err != nil {
+			logger.Warnf("[%s] Could not build instance for %s: %s", r.name, service.Service, err)
+			skipped[id] = err.Error()
+			r.failed++
+			continue
+		}
+
+		if unused := params.Unused(); len(unused) > 0 {
+			logger.Warnf("[%s] Service %s has unknown parameters: %s", r.name, service.Service, strings.Join(unused, ", "))
+		}
+
+		if check == nil {
+			skipped[id] = "backend did not return an instance"
+			continue
+		}
+
+		entries = append(entries, &entry{check: check, service: service})
+	}
+
+	// Sort the services by name so we get consistent output across runs
+	sort.Sort(entrySorter(entries))
+
+	oldHash := r.files.hash()
+	staged := make([]*stagedFile, 0)
+
+	for _, filePath := range r.files.paths(entries) {
+		data, err := r.render(r.files.entriesFor(filePath, entries))
+		if err != nil {
+			return err
+		}
+
+		hash := cfg.ContentHash(data)
+		if hash == r.files.hashes[filePath] {
+			logger.Infof("[%s] File hash is the same, NOOP", r.name)
+			continue
+		}
+
+		// content the validation command already rejected would fail again, and
+		// again on every retry, so wait for the services to change instead
+		if hash == r.files.rejected[filePath] {
+			logger.Warnf("[%s] Not writing %s, the validation command rejected the same content before", r.name, filePath)
+			continue
+		}
+
+		staged = append(staged, &stagedFile{path: filePath, data: data, hash: hash})
+	}
+
+	// nothing is written to the agent's directory before the validation command passed
+	if err := r.validate(staged); err != nil {
+		return err
+	}
+
+	changed := false
+	for _, file := range staged {
+		if err := r.files.prepare(file.path); err != nil {
+			return err
+		}
+
+		fileChanged, newHash, err := cfg.WriteIfChange(r.name, file.path, file.data, r.files.hashes[file.path])
+		if err != nil {
+			fileWrites.Inc(r.name, "failure")
+			fileWriteErrors.Add(r.name, 1)
+			return err
+		}
+
+		if fileChanged {
+			fileWrites.Inc(r.name, "success")
+			changed = true
+		}
+
+		r.files.hashes[file.path] = newHash
+	}
+
+	removed, err := r.files.removeStale(entries)
+	if err != nil {
+		return err
+	}
+
+	r.report(entries, skipped)
+	r.announce(entries)
+
+	if !changed && !removed {
+		return nil
+	}
+
+	r.payload.ReloadCh <- cfg.ReloadPayload{
+		Service: r.name,
+		Check:   r.backend.CheckName(),
+		OldHash: oldHash,
+		NewHash: r.files.hash(),
+	}
+
+	return nil
+}
+
+// render the dd-agent config file for the entries
+func (r *runner) render(entries []*entry) ([]byte, error) {
+	t := &Config{}
+	for _, e := range entries {
+		tags := r.payload.Settings.Tags.ServiceTags(r.payload.NodeName, e.service.AgentService)
+		if r.settings.HealthTag {
+			tags = append(tags, "consul_health:"+e.service.Health)
+		}
+
+		instance, err := customize(e.check, r.settings.InstanceDefaults, tags)
+		if err != nil {
+			return nil, fmt.Errorf("Could not customize instance for %s: %s", e.service.Service, err)
+		}
+
+		e.rendered = instance
+		t.Instances = append(t.Instances, instance)
+	}
+
+	data, err := yaml.Marshal(&t)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal yaml: %s", err)
+	}
+
+	return data, nil
+}
+
+// entry is an instance together with the service it was built for
+type entry struct {
+	check    Instance
+	service  *cfg.Service
+	rendered interface{}
+}
+
+// entrySorter sorts entries by the sort key of their instance
+type entrySorter []*entry
+
+func (a entrySorter) Len() int           { return len(a) }
+func (a entrySorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a entrySorter) Less(i, j int) bool { return a[i].check.SortKey() < a[j].check.SortKey() }
+
+// customize adds the configured default options and extra tags to an instance,
+// options set by the backend itself always win over defaults
+func customize(check Instance, defaults map[string]interface{}, tags []string) (interface{}, error) {
+	if len(defaults) == 0 && len(tags) == 0 {
+		return check, nil
+	}
+
+	data, err := yaml.Marshal(check)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &instance); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool)
+	for _, item := range instance {
+		existing[fmt.Sprintf("%v", item.Key)] = true
+	}
+
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		if !existing[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		instance = append(instance, yaml.MapItem{Key: key, Value: defaults[key]})
+	}
+
+	if len(tags) == 0 {
+		return instance, nil
+	}
+
+	for i, item := range instance {
+		if fmt.Sprintf("%v", item.Key) != "tags" {
+			continue
+		}
+
+		list, _ := item.Value.([]interface{})
+		seen := make(map[string]bool)
+		for _, tag := range list {
+			seen[fmt.Sprintf("%v", tag)] = true
+		}
+
+		for _, tag := range tags {
+			if !seen[tag] {
+				list = append(list, tag)
+				seen[tag] = true
+			}
+		}
+		instance[i].Value = list
+
+		return instance, nil
+	}
+
+	list := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		list = append(list, tag)
+	}
+
+	return append(instance, yaml.MapItem{Key: "tags", Value: list}), nil
+}
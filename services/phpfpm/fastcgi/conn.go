@@ -0,0 +1,216 @@
+// Package fastcgi is a minimal FastCGI client with deadlines and keep-alive
+// connections, just enough to talk to the php-fpm status and ping pages
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// record types, see https://fast-cgi.github.io/spec
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+	flagKeepConn  = 1
+
+	// we never have more than one request in flight on a connection
+	requestID = 1
+
+	maxContent = 65535
+)
+
+// Response is the CGI response of the application
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stderr is what the application logged, e.g. PHP warnings
+	Stderr []byte
+}
+
+// Conn is a connection to a FastCGI application
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	keepAlive bool
+	idleSince time.Time
+}
+
+// Dial connects to the FastCGI application, network is "tcp" or "unix"
+func Dial(network, address string, timeout time.Duration, keepAlive bool) (*Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), keepAlive: keepAlive}, nil
+}
+
+// Close the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Request sends a request with the CGI params and returns the response, it fails
+// with a timeout error if the response isn't complete before the deadline
+func (c *Conn) Request(params map[string]string, deadline time.Time) (*Response, error) {
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var flags byte
+	if c.keepAlive {
+		flags = flagKeepConn
+	}
+
+	var buf bytes.Buffer
+	writeRecord(&buf, typeBeginRequest, []byte{0, roleResponder, flags, 0, 0, 0, 0, 0})
+	writeStream(&buf, typeParams, encodeParams(params))
+	writeStream(&buf, typeStdin, nil)
+
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	for {
+		recordType, content, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch recordType {
+		case typeStdout:
+			stdout.Write(content)
+		case typeStderr:
+			stderr.Write(content)
+		case typeEndRequest:
+			// the protocol status, e.g. php-fpm refusing the request because it's overloaded
+			if len(content) >= 5 && content[4] != 0 {
+				return nil, fmt.Errorf("FastCGI request rejected by the application (protocol status %d)", content[4])
+			}
+
+			response, err := parseResponse(stdout.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			response.Stderr = stderr.Bytes()
+			return response, nil
+		}
+	}
+}
+
+func (c *Conn) readRecord() (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	if header[0] != 1 {
+		return 0, nil, fmt.Errorf("Unsupported FastCGI version %d", header[0])
+	}
+
+	contentLength := binary.BigEndian.Uint16(header[4:6])
+	paddingLength := header[6]
+
+	content := make([]byte, int(contentLength)+int(paddingLength))
+	if _, err := io.ReadFull(c.reader, content); err != nil {
+		return 0, nil, err
+	}
+
+	return header[1], content[:contentLength], nil
+}
+
+func writeRecord(buf *bytes.Buffer, recordType byte, content []byte) {
+	header := []byte{1, recordType, 0, requestID, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+
+	buf.Write(header)
+	buf.Write(content)
+}
+
+// writeStream writes content split into records, followed by the empty record ending the stream
+func writeStream(buf *bytes.Buffer, recordType byte, content []byte) {
+	for len(content) > 0 {
+		n := len(content)
+		if n > maxContent {
+			n = maxContent
+		}
+
+		writeRecord(buf, recordType, content[:n])
+		content = content[n:]
+	}
+
+	writeRecord(buf, recordType, nil)
+}
+
+// encodeParams encodes the CGI params as FastCGI name-value pairs
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range params {
+		writeLength(&buf, len(name))
+		writeLength(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+
+	return buf.Bytes()
+}
+
+func writeLength(buf *bytes.Buffer, length int) {
+	if length < 128 {
+		buf.WriteByte(byte(length))
+		return
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(length)|1<<31)
+	buf.Write(b)
+}
+
+// parseResponse splits the CGI headers from the body, the status comes from the "Status" header
+func parseResponse(stdout []byte) (*Response, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(stdout)))
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Could not parse FastCGI response headers: %s", err)
+	}
+	if header == nil {
+		header = make(textproto.MIMEHeader)
+	}
+
+	body, err := ioutil.ReadAll(reader.R)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &Response{Status: http.StatusOK, Header: http.Header(header), Body: body}
+
+	if status := header.Get("Status"); status != "" {
+		code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid FastCGI status %q", status)
+		}
+		response.Status = code
+		response.Header.Del("Status")
+	}
+
+	return response, nil
+}
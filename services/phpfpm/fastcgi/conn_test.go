@@ -0,0 +1,249 @@
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeApp is a FastCGI application on a local TCP port, handle is called for every connection
+type fakeApp struct {
+	listener net.Listener
+	handle   func(c *Conn)
+	accepted int
+	mutex    sync.Mutex
+}
+
+func newFakeApp(t *testing.T, handle func(c *Conn)) *fakeApp {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &fakeApp{listener: listener, handle: handle}
+	go app.serve()
+	return app
+}
+
+func (a *fakeApp) serve() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		a.mutex.Lock()
+		a.accepted++
+		a.mutex.Unlock()
+
+		go func() {
+			defer conn.Close()
+			a.handle(&Conn{conn: conn, reader: bufio.NewReader(conn)})
+		}()
+	}
+}
+
+func (a *fakeApp) address() string {
+	return a.listener.Addr().String()
+}
+
+func (a *fakeApp) connections() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.accepted
+}
+
+func (a *fakeApp) Close() {
+	a.listener.Close()
+}
+
+// readRequest reads the records of a request, up to the end of stdin
+func readRequest(c *Conn) error {
+	for {
+		recordType, content, err := c.readRecord()
+		if err != nil {
+			return err
+		}
+
+		if recordType == typeStdin && len(content) == 0 {
+			return nil
+		}
+	}
+}
+
+// respond writes stdout and ends the request with the protocol status
+func respond(c *Conn, stdout string, protocolStatus byte) error {
+	var buf bytes.Buffer
+	writeStream(&buf, typeStdout, []byte(stdout))
+	writeRecord(&buf, typeEndRequest, []byte{0, 0, 0, 0, protocolStatus, 0, 0, 0})
+
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func TestWriteLength(t *testing.T) {
+	tests := []struct {
+		length int
+		want   []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{127}},
+		{128, []byte{0x80, 0, 0, 128}},
+		{300, []byte{0x80, 0, 1, 44}},
+		{70000, []byte{0x80, 1, 0x11, 0x70}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeLength(&buf, test.length)
+
+		if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("writeLength(%d) = %v, want %v", test.length, buf.Bytes(), test.want)
+		}
+	}
+}
+
+func TestEncodeParams(t *testing.T) {
+	long := strings.Repeat("x", 128)
+
+	tests := []struct {
+		name  string
+		value string
+		want  []byte
+	}{
+		{"A", "b", []byte("\x01\x01Ab")},
+		{"A", "", []byte("\x01\x00A")},
+		{"A", long, append([]byte("\x01\x80\x00\x00\x80A"), long...)},
+		{long, "b", append(append([]byte("\x80\x00\x00\x80\x01"), long...), 'b')},
+	}
+
+	for _, test := range tests {
+		got := encodeParams(map[string]string{test.name: test.value})
+
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("encodeParams(%q: %q) = %q, want %q", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		stdout      string
+		status      int
+		contentType string
+		body        string
+		err         bool
+	}{
+		{"Content-Type: text/plain\r\n\r\npong", 200, "text/plain", "pong", false},
+		{"Status: 404 Not Found\r\nContent-Type: text/html\r\n\r\nFile not found.", 404, "text/html", "File not found.", false},
+		{"Status: 503\r\n\r\n", 503, "", "", false},
+		{"", 200, "", "", false},
+		{"Status: unknown\r\n\r\n", 0, "", "", true},
+	}
+
+	for _, test := range tests {
+		response, err := parseResponse([]byte(test.stdout))
+		if test.err {
+			if err == nil {
+				t.Errorf("parseResponse(%q) should fail", test.stdout)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseResponse(%q) failed: %s", test.stdout, err)
+			continue
+		}
+
+		if response.Status != test.status {
+			t.Errorf("parseResponse(%q) status = %d, want %d", test.stdout, response.Status, test.status)
+		}
+
+		if got := response.Header.Get("Content-Type"); got != test.contentType {
+			t.Errorf("parseResponse(%q) Content-Type = %q, want %q", test.stdout, got, test.contentType)
+		}
+
+		if response.Header.Get("Status") != "" {
+			t.Errorf("parseResponse(%q) should remove the Status header", test.stdout)
+		}
+
+		if string(response.Body) != test.body {
+			t.Errorf("parseResponse(%q) body = %q, want %q", test.stdout, response.Body, test.body)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		stdout         string
+		protocolStatus byte
+		status         int
+		body           string
+		err            bool
+	}{
+		{"ping", "Content-Type: text/plain\r\n\r\npong", 0, 200, "pong", false},
+		{"not found", "Status: 404 Not Found\r\n\r\nFile not found.", 0, 404, "File not found.", false},
+		{"empty stdout", "", 0, 200, "", false},
+		{"overloaded", "", 2, 0, "", true},
+		{"unknown role", "", 3, 0, "", true},
+	}
+
+	for _, test := range tests {
+		test := test
+		app := newFakeApp(t, func(c *Conn) {
+			if readRequest(c) == nil {
+				respond(c, test.stdout, test.protocolStatus)
+			}
+		})
+
+		conn, err := Dial("tcp", app.address(), time.Second, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := conn.Request(map[string]string{"SCRIPT_NAME": "/ping"}, time.Now().Add(time.Second))
+		conn.Close()
+		app.Close()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: request should fail", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: request failed: %s", test.name, err)
+			continue
+		}
+
+		if response.Status != test.status || string(response.Body) != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, response.Status, response.Body, test.status, test.body)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// never answers
+	app := newFakeApp(t, func(c *Conn) {
+		readRequest(c)
+		c.readRecord()
+	})
+	defer app.Close()
+
+	conn, err := Dial("tcp", app.address(), time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Request(map[string]string{}, time.Now().Add(50*time.Millisecond))
+	if !IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package fastcgi
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when an upstream already has the maximum number of requests in flight
+var ErrBusy = errors.New("Too many concurrent requests to the FastCGI application")
+
+// Options control the connections to every upstream
+type Options struct {
+	DialTimeout time.Duration
+	// ReadTimeout is the time allowed for the full request once connected
+	ReadTimeout time.Duration
+	// MaxConcurrent limits the requests in flight per upstream, 0 means no limit
+	MaxConcurrent int
+	// KeepAlive reuses connections, keeping at most MaxIdle idle connections per
+	// upstream for IdleTimeout
+	KeepAlive   bool
+	MaxIdle     int
+	IdleTimeout time.Duration
+}
+
+// Pool hands out connections to FastCGI applications, keyed by network and address
+type Pool struct {
+	options   Options
+	upstreams map[string]*upstream
+	done      chan struct{}
+	mutex     sync.Mutex
+}
+
+// upstream is a single FastCGI application
+type upstream struct {
+	network string
+	address string
+	slots   chan struct{}
+	idle    []*Conn
+	// active counts the requests using the upstream, it is only forgotten
+	// once unused since lastUsed
+	active   int
+	lastUsed time.Time
+	mutex    sync.Mutex
+}
+
+// NewPool returns an empty pool, expired idle connections and unused upstreams
+// are cleaned up in the background until the pool is closed
+func NewPool(options Options) *Pool {
+	p := &Pool{options: options, upstreams: make(map[string]*upstream), done: make(chan struct{})}
+	go p.reaper()
+	return p
+}
+
+// Close stops the background cleanup and closes the idle connections
+func (p *Pool) Close() {
+	close(p.done)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, u := range p.upstreams {
+		u.mutex.Lock()
+		for _, conn := range u.idle {
+			conn.Close()
+		}
+		u.idle = nil
+		u.mutex.Unlock()
+	}
+
+	p.upstreams = make(map[string]*upstream)
+}
+
+// Request sends a request to the application at address, waiting at most
+// DialTimeout for a free slot and ReadTimeout for the response
+func (p *Pool) Request(network, address string, params map[string]string) (*Response, error) {
+	u := p.acquire(network, address)
+	defer p.release(u)
+
+	if u.slots != nil {
+		select {
+		case u.slots <- struct{}{}:
+			defer func() { <-u.slots }()
+		case <-time.After(p.options.DialTimeout):
+			return nil, ErrBusy
+		}
+	}
+
+	conn, reused, err := p.get(u)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := conn.Request(params, time.Now().Add(p.options.ReadTimeout))
+
+	// the application may have closed an idle connection in the meantime, try once more on a new one
+	if err != nil && reused && !IsTimeout(err) {
+		conn.Close()
+
+		conn, err = Dial(network, address, p.options.DialTimeout, p.options.KeepAlive)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err = conn.Request(params, time.Now().Add(p.options.ReadTimeout))
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.put(u, conn)
+	return response, nil
+}
+
+// acquire returns the upstream for address, marked as in use until it is released
+func (p *Pool) acquire(network, address string) *upstream {
+	key := network + "://" + address
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	u, found := p.upstreams[key]
+	if !found {
+		u = &upstream{network: network, address: address}
+		if p.options.MaxConcurrent > 0 {
+			u.slots = make(chan struct{}, p.options.MaxConcurrent)
+		}
+		p.upstreams[key] = u
+	}
+
+	u.mutex.Lock()
+	u.active++
+	u.mutex.Unlock()
+
+	return u
+}
+
+func (p *Pool) release(u *upstream) {
+	u.mutex.Lock()
+	u.active--
+	u.lastUsed = time.Now()
+	u.mutex.Unlock()
+}
+
+// reapInterval is how often the pool is cleaned up, it's also how long an
+// upstream is kept after its last request
+func (p *Pool) reapInterval() time.Duration {
+	if p.options.KeepAlive && p.options.IdleTimeout > 0 {
+		return p.options.IdleTimeout
+	}
+
+	return time.Minute
+}
+
+func (p *Pool) reaper() {
+	ticker := time.NewTicker(p.reapInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.reap(now)
+		}
+	}
+}
+
+// reap closes the expired idle connections and forgets the upstreams nobody
+// used for a while, e.g. the old port of a service that moved
+func (p *Pool) reap(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, u := range p.upstreams {
+		u.mutex.Lock()
+
+		idle := u.idle[:0]
+		for _, conn := range u.idle {
+			if now.Sub(conn.idleSince) < p.options.IdleTimeout {
+				idle = append(idle, conn)
+				continue
+			}
+			conn.Close()
+		}
+		u.idle = idle
+
+		unused := u.active == 0 && len(u.idle) == 0 && now.Sub(u.lastUsed) >= p.reapInterval()
+
+		u.mutex.Unlock()
+
+		if unused {
+			delete(p.upstreams, key)
+		}
+	}
+}
+
+// get returns an idle connection, or a new one
+func (p *Pool) get(u *upstream) (*Conn, bool, error) {
+	u.mutex.Lock()
+	for len(u.idle) > 0 {
+		conn := u.idle[len(u.idle)-1]
+		u.idle = u.idle[:len(u.idle)-1]
+
+		if time.Since(conn.idleSince) < p.options.IdleTimeout {
+			u.mutex.Unlock()
+			return conn, true, nil
+		}
+
+		conn.Close()
+	}
+	u.mutex.Unlock()
+
+	conn, err := Dial(u.network, u.address, p.options.DialTimeout, p.options.KeepAlive)
+	return conn, false, err
+}
+
+// put keeps the connection for the next request, or closes it
+func (p *Pool) put(u *upstream, conn *Conn) {
+	if !p.options.KeepAlive {
+		conn.Close()
+		return
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if len(u.idle) >= p.options.MaxIdle {
+		conn.Close()
+		return
+	}
+
+	conn.idleSince = time.Now()
+	u.idle = append(u.idle, conn)
+}
+
+// IsTimeout returns true if the request failed because a dial or read deadline passed
+func IsTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
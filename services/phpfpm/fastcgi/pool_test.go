@@ -0,0 +1,171 @@
+package fastcgi
+
+import (
+	"testing"
+	"time"
+)
+
+const pong = "Content-Type: text/plain\r\n\r\npong"
+
+func TestPoolKeepAlive(t *testing.T) {
+	tests := []struct {
+		name        string
+		keepAlive   bool
+		requests    int
+		connections int
+	}{
+		{"without keep-alive", false, 3, 3},
+		{"with keep-alive", true, 3, 1},
+	}
+
+	for _, test := range tests {
+		app := newFakeApp(t, func(c *Conn) {
+			for readRequest(c) == nil {
+				respond(c, pong, 0)
+			}
+		})
+
+		pool := NewPool(Options{DialTimeout: time.Second, ReadTimeout: time.Second, KeepAlive: test.keepAlive, MaxIdle: 1, IdleTimeout: time.Minute})
+
+		for i := 0; i < test.requests; i++ {
+			if _, err := pool.Request("tcp", app.address(), map[string]string{}); err != nil {
+				t.Errorf("%s: request failed: %s", test.name, err)
+			}
+		}
+
+		if got := app.connections(); got != test.connections {
+			t.Errorf("%s: %d connections, want %d", test.name, got, test.connections)
+		}
+
+		pool.Close()
+		app.Close()
+	}
+}
+
+func TestPoolRetriesReusedConnection(t *testing.T) {
+	// answers a single request per connection, like php-fpm closing an idle connection
+	app := newFakeApp(t, func(c *Conn) {
+		if readRequest(c) == nil {
+			respond(c, pong, 0)
+		}
+	})
+	defer app.Close()
+
+	pool := NewPool(Options{DialTimeout: time.Second, ReadTimeout: time.Second, KeepAlive: true, MaxIdle: 1, IdleTimeout: time.Minute})
+	defer pool.Close()
+
+	for i := 0; i < 2; i++ {
+		response, err := pool.Request("tcp", app.address(), map[string]string{})
+		if err != nil {
+			t.Fatalf("request %d failed: %s", i, err)
+		}
+		if string(response.Body) != "pong" {
+			t.Fatalf("request %d: got %q", i, response.Body)
+		}
+	}
+
+	if got := app.connections(); got != 2 {
+		t.Errorf("%d connections, want 2", got)
+	}
+}
+
+func TestPoolDoesNotRetryTimeouts(t *testing.T) {
+	// answers the first request of a connection, and never the second
+	app := newFakeApp(t, func(c *Conn) {
+		if readRequest(c) == nil {
+			respond(c, pong, 0)
+		}
+		readRequest(c)
+		c.readRecord()
+	})
+	defer app.Close()
+
+	pool := NewPool(Options{DialTimeout: time.Second, ReadTimeout: 50 * time.Millisecond, KeepAlive: true, MaxIdle: 1, IdleTimeout: time.Minute})
+	defer pool.Close()
+
+	if _, err := pool.Request("tcp", app.address(), map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Request("tcp", app.address(), map[string]string{}); !IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	if got := app.connections(); got != 1 {
+		t.Errorf("%d connections, want 1", got)
+	}
+}
+
+func TestPoolBusy(t *testing.T) {
+	received := make(chan bool)
+	release := make(chan bool)
+
+	app := newFakeApp(t, func(c *Conn) {
+		if readRequest(c) == nil {
+			received <- true
+			<-release
+			respond(c, pong, 0)
+		}
+	})
+	defer app.Close()
+
+	pool := NewPool(Options{DialTimeout: 50 * time.Millisecond, ReadTimeout: time.Second, MaxConcurrent: 1})
+	defer pool.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := pool.Request("tcp", app.address(), map[string]string{})
+		done <- err
+	}()
+	<-received
+
+	if _, err := pool.Request("tcp", app.address(), map[string]string{}); err != ErrBusy {
+		t.Errorf("expected ErrBusy, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("first request failed: %s", err)
+	}
+}
+
+func TestPoolReap(t *testing.T) {
+	app := newFakeApp(t, func(c *Conn) {
+		for readRequest(c) == nil {
+			respond(c, pong, 0)
+		}
+	})
+	defer app.Close()
+
+	pool := NewPool(Options{DialTimeout: time.Second, ReadTimeout: time.Second, KeepAlive: true, MaxIdle: 1, IdleTimeout: time.Minute})
+	defer pool.Close()
+
+	if _, err := pool.Request("tcp", app.address(), map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		after     time.Duration
+		idle      int
+		upstreams int
+	}{
+		{time.Second, 1, 1},
+		{2 * time.Minute, 0, 0},
+	}
+
+	for _, test := range tests {
+		pool.reap(time.Now().Add(test.after))
+
+		pool.mutex.Lock()
+		upstreams := len(pool.upstreams)
+		idle := 0
+		for _, u := range pool.upstreams {
+			idle += len(u.idle)
+		}
+		pool.mutex.Unlock()
+
+		if idle != test.idle || upstreams != test.upstreams {
+			t.Errorf("after %s: %d idle connections and %d upstreams, want %d and %d", test.after, idle, upstreams, test.idle, test.upstreams)
+		}
+	}
+}
@@ -2,80 +2,253 @@ package phpfpm
 
 import (
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/scukonick/go-fastcgi-client"
+	observer "github.com/imkira/go-observer"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/metrics"
+	"github.com/seatgeek/datadog-service-helper/services/phpfpm/fastcgi"
 )
 
-// Connect to the upstream php-fpm process and get its current status
-func Proxy(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
+var proxyRequestCounter = metrics.NewExpvarInt("php_fpm_proxy_requests", metrics.Rate)
+var proxyErrorCounter = metrics.NewExpvarInt("php_fpm_proxy_errors", metrics.Rate)
+var proxyLatency = metrics.NewExpvarFloat("php_fpm_proxy_latency_ms", metrics.Gauge)
+var proxyRequests = metrics.NewCounterVec("phpfpm_proxy_requests_total", "Requests proxied to php-fpm by type and HTTP status.", "type", "status")
+var proxyRequestDuration = metrics.NewHistogramVec("phpfpm_proxy_request_duration_seconds", "Duration of requests proxied to php-fpm.", metrics.DefaultBuckets, "type")
+var proxyRejectedCounter = metrics.NewExpvarInt("php_fpm_proxy_rejected", metrics.Rate)
+var proxyRejected = metrics.NewCounterVec("phpfpm_proxy_rejected_total", "Requests refused by the php-fpm proxy by reason (type, unregistered or socket).", "reason")
+
+// endpoints are the only php-fpm pages the proxy serves
+var endpoints = map[string]bool{"ping": true, "status": true}
+
+// rejectedError is a request for something the proxy won't connect to
+type rejectedError struct {
+	reason  string
+	message string
+}
+
+func (e *rejectedError) Error() string { return e.message }
+
+// statusRecorder remembers the HTTP status sent to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Proxy forwards the HTTP requests of the php-fpm check to php-fpm over fastcgi,
+// but only to the php-fpm services currently registered on our node
+type Proxy struct {
+	settings *cfg.PHPFPMSettings
+	services observer.Property
+	tag      string
+	pool     *fastcgi.Pool
+}
+
+// NewProxy returns a proxy for the services in the payload
+func NewProxy(payload *cfg.ServicePayload) *Proxy {
+	backend := &Backend{}
+
+	tag := payload.Settings.Backend(backend.Name()).Tag
+	if tag == "" {
+		tag = "dd-" + backend.TagSuffix()
+	}
+
+	settings := &payload.Settings.PHPFPM
+
+	return &Proxy{
+		settings: settings,
+		services: payload.Services,
+		tag:      tag,
+		pool: fastcgi.NewPool(fastcgi.Options{
+			DialTimeout:   settings.DialTimeout,
+			ReadTimeout:   settings.ReadTimeout,
+			MaxConcurrent: settings.MaxConcurrent,
+			KeepAlive:     settings.KeepAlive,
+			MaxIdle:       settings.MaxIdle,
+			IdleTimeout:   settings.IdleTimeout,
+		}),
+	}
+}
+
+// registered returns the php-fpm service of the project that matches, if any
+func (p *Proxy) registered(project string, match func(service *cfg.Service) bool) *cfg.Service {
+	for _, service := range p.services.Value().(map[string]*cfg.Service) {
+		if service.Service == project && cfg.HasTag(p.tag, service.Tags) && match(service) {
+			return service
+		}
+	}
 
-	// variables we require to have present in the URL
-	// they always exist thanks to the router
+	return nil
+}
+
+// upstreamFunc returns the network and address of the upstream of the request and
+// the service it belongs to, or why it can't be used
+type upstreamFunc func(r *http.Request) (string, string, *cfg.Service, error)
+
+// TCP connects to the upstream php-fpm process over TCP and gets its current status
+func (p *Proxy) TCP(w http.ResponseWriter, r *http.Request) {
+	p.serve(w, r, p.tcpUpstream)
+}
+
+// Unix connects to the upstream php-fpm process over the unix socket in the
+// "socket" query parameter, which must be allowed by php_fpm.sockets
+func (p *Proxy) Unix(w http.ResponseWriter, r *http.Request) {
+	p.serve(w, r, p.unixUpstream)
+}
+
+func (p *Proxy) tcpUpstream(r *http.Request) (string, string, *cfg.Service, error) {
+	params := mux.Vars(r)
 	project := params["project"]
 	ip := params["ip"]
 	port := params["port"]
-	endpoint := params["type"]
 
 	// convert the string port to int
 	realPort, err := strconv.Atoi(port)
 	if err != nil {
-		message := fmt.Sprintf("[php-fpm] Invalid port %s: %s", port, err)
-		logger.Errorf(message)
-		http.Error(w, message, 500)
-		return
+		return "", "", nil, fmt.Errorf("Invalid port %s: %s", port, err)
 	}
 
-	// construct the env we need for php-fpm to allow ac
-	env := make(map[string]string)
-	env["REQUEST_METHOD"] = "GET"
-	env["SCRIPT_FILENAME"] = fmt.Sprintf("/%s/internal/%s", project, endpoint)
-	env["SCRIPT_NAME"] = fmt.Sprintf("/%s/internal/%s", project, endpoint)
-	env["SERVER_SOFTWARE"] = "go / fcgiclient "
-	env["QUERY_STRING"] = "json=1"
+	service := p.registered(project, func(service *cfg.Service) bool {
+		return service.Address == ip && service.Port == realPort
+	})
+	if service == nil {
+		return "", "", nil, &rejectedError{"unregistered", fmt.Sprintf("No %s service %s registered at %s:%d", p.tag, project, ip, realPort)}
+	}
 
-	// create fastcgi client
-	fcgi, err := fcgiclient.New(ip, realPort)
-	if err != nil {
-		message := fmt.Sprintf("[php-fpm] Could not create fastcgi client: %s (%s)", err, r.URL.Path)
-		logger.Errorf(message)
-		http.Error(w, message, 500)
-		return
+	return "tcp", net.JoinHostPort(ip, port), service, nil
+}
+
+func (p *Proxy) unixUpstream(r *http.Request) (string, string, *cfg.Service, error) {
+	project := mux.Vars(r)["project"]
+	socket := r.URL.Query().Get("socket")
+
+	if !p.settings.AllowsSocket(socket) {
+		return "", "", nil, &rejectedError{"socket", fmt.Sprintf("Socket %q is not allowed by php_fpm.sockets", socket)}
+	}
+
+	service := p.registered(project, func(service *cfg.Service) bool {
+		return cfg.ServiceParams(p.tag, service.AgentService).String("socket", "") == socket
+	})
+	if service == nil {
+		return "", "", nil, &rejectedError{"unregistered", fmt.Sprintf("No %s service %s registered with socket %s", p.tag, project, socket)}
+	}
+
+	return "unix", socket, service, nil
+}
+
+// serve does the fastcgi request on the upstream of the request
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, upstream upstreamFunc) {
+	params := mux.Vars(r)
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = recorder
+
+	// the type always exists thanks to the router, the project is checked by upstream
+	endpoint := params["type"]
+
+	// don't let callers pick arbitrary metric labels
+	label := endpoint
+	if !endpoints[endpoint] {
+		label = "other"
+	}
+
+	start := time.Now()
+	defer func() {
+		proxyRequests.Inc(label, strconv.Itoa(recorder.status))
+		proxyRequestDuration.Since(start, label)
+
+		proxyRequestCounter.Add(1)
+		proxyLatency.Set(float64(time.Since(start)) / float64(time.Millisecond))
+		if recorder.status != http.StatusOK {
+			proxyErrorCounter.Add(1)
+		}
+	}()
+
+	var network, address string
+	var service *cfg.Service
+	var err error
+	if endpoints[endpoint] {
+		network, address, service, err = upstream(r)
+	} else {
+		err = &rejectedError{"type", fmt.Sprintf("Type %q is not allowed, only ping and status are", endpoint)}
 	}
 
-	// do the fastcgi request
-	response, err := fcgi.Request(env, "json=1")
 	if err != nil {
-		message := fmt.Sprintf("[php-fpm] Failed fastcgi request: %s (%s)", err, r.URL.Path)
-		logger.Errorf(message)
+		message := fmt.Sprintf("[php-fpm] %s (%s)", err, r.URL.Path)
+		logger.Warn(message)
+
+		if rejected, ok := err.(*rejectedError); ok {
+			proxyRejectedCounter.Add(1)
+			proxyRejected.Inc(rejected.reason)
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
+
 		http.Error(w, message, 500)
 		return
 	}
 
-	// parse the fastcgi response
-	body, err := response.ParseStdouts()
+	pool, err := newPoolSettings(p.settings, service.AgentService, cfg.ServiceParams(p.tag, service.AgentService))
 	if err != nil {
-		message := fmt.Sprintf("[php-fpm] Failed to parse fastcgi response: %s (%s)", err, r.URL.Path)
+		message := fmt.Sprintf("[php-fpm] %s (%s)", err, r.URL.Path)
 		logger.Errorf(message)
 		http.Error(w, message, 500)
 		return
 	}
 
-	// read the response into a []bytes
-	resp, err := ioutil.ReadAll(body.Body)
+	// construct the env we need for php-fpm to allow ac
+	env := make(map[string]string)
+	env["REQUEST_METHOD"] = "GET"
+	env["SCRIPT_FILENAME"] = pool.path(endpoint)
+	env["SCRIPT_NAME"] = pool.path(endpoint)
+	env["SERVER_SOFTWARE"] = "go / fastcgi"
+	env["QUERY_STRING"] = "json=1"
+
+	// the extra params of the pool win, e.g. a different SCRIPT_FILENAME
+	for name, value := range pool.params {
+		env[name] = value
+	}
+
+	// do the fastcgi request, timeouts map to 504 so the check reports the pool as down quickly
+	response, err := p.pool.Request(network, address, env)
 	if err != nil {
-		message := fmt.Sprintf("[php-fpm] Failed to read fastcgi response: %s (%s)", err, r.URL.Path)
+		message := fmt.Sprintf("[php-fpm] Failed fastcgi request: %s (%s)", err, r.URL.Path)
 		logger.Errorf(message)
-		http.Error(w, message, 500)
+		http.Error(w, message, errorStatus(err))
 		return
 	}
 
+	if len(response.Stderr) > 0 {
+		logger.Debugf("[php-fpm] fastcgi stderr: %s (%s)", response.Stderr, r.URL.Path)
+	}
+
 	// write to client
-	w.Write(resp)
+	if contentType := response.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+
+	logger.Debugf("[php-fpm] Request complete. Sent %d bytes (%s)", len(response.Body), r.URL.Path)
+}
 
-	logger.Debugf("[php-fpm] Request complete. Sent %d bytes (%s)", len(resp), r.URL.Path)
+// errorStatus is the HTTP status for a failed fastcgi request
+func errorStatus(err error) int {
+	switch {
+	case fastcgi.IsTimeout(err):
+		return http.StatusGatewayTimeout
+	case err == fastcgi.ErrBusy:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
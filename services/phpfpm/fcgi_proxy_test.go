@@ -0,0 +1,65 @@
+package phpfpm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/seatgeek/datadog-service-helper/services/phpfpm/fastcgi"
+)
+
+func TestErrorStatus(t *testing.T) {
+	// accepts connections and never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// nothing listens on the port once closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	pool := fastcgi.NewPool(fastcgi.Options{DialTimeout: time.Second, ReadTimeout: 50 * time.Millisecond})
+	defer pool.Close()
+
+	_, timeoutErr := pool.Request("tcp", silent.Addr().String(), map[string]string{})
+	_, refusedErr := pool.Request("tcp", closed.Addr().String(), map[string]string{})
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"read deadline", timeoutErr, http.StatusGatewayTimeout},
+		{"busy", fastcgi.ErrBusy, http.StatusServiceUnavailable},
+		{"connection refused", refusedErr, http.StatusInternalServerError},
+		{"other", errors.New("FastCGI request rejected by the application (protocol status 2)"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if test.err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+
+		if got := errorStatus(test.err); got != test.status {
+			t.Errorf("%s: errorStatus(%q) = %d, want %d", test.name, test.err, got, test.status)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package phpfpm
+
+import (
+	"fmt"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+// poolSettings describe the status and ping pages of a php-fpm pool
+type poolSettings struct {
+	statusPath string
+	pingPath   string
+	pingReply  string
+	// params are the extra FastCGI params sent with every request
+	params map[string]string
+}
+
+// newPoolSettings combines the php_fpm settings with the parameters of the service, e.g.
+// Meta "dd-php-fpm-status-path" = "/status" or "dd-php-fpm-fastcgi-HTTP_HOST" = "app.local"
+func newPoolSettings(settings *cfg.PHPFPMSettings, service *consul.AgentService, params *cfg.Params) (*poolSettings, error) {
+	expand := func(path string) string {
+		return strings.Replace(path, "{project}", service.Service, -1)
+	}
+
+	pool := &poolSettings{
+		statusPath: expand(params.String("status_path", settings.StatusPath)),
+		pingPath:   expand(params.String("ping_path", settings.PingPath)),
+		pingReply:  params.String("ping_reply", settings.PingReply),
+		params:     make(map[string]string),
+	}
+
+	if !strings.HasPrefix(pool.statusPath, "/") || !strings.HasPrefix(pool.pingPath, "/") {
+		return nil, fmt.Errorf("Invalid parameters status_path %q and ping_path %q, they must start with /", pool.statusPath, pool.pingPath)
+	}
+
+	for name, value := range settings.Params {
+		pool.params[name] = value
+	}
+
+	// parameter keys are lowercased, FastCGI params are uppercase by convention
+	for name, value := range params.Prefixed("fastcgi_") {
+		pool.params[strings.ToUpper(name)] = value
+	}
+
+	return pool, nil
+}
+
+// path returns the script path of the ping or status page
+func (p *poolSettings) path(endpoint string) string {
+	if endpoint == "ping" {
+		return p.pingPath
+	}
+
+	return p.statusPath
+}
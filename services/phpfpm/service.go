@@ -2,94 +2,66 @@ package phpfpm
 
 import (
 	"fmt"
-	"os"
-	"sort"
+	"net/url"
 
 	consul "github.com/hashicorp/consul/api"
 	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/services"
 	"github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
 )
 
 var (
 	logger = logrus.New()
 )
 
-// continuously monitor the local agent services for php-fpm services
-// and register them to the local datadog client
-func Observe(payload *cfg.ServicePayload) {
-	filePath := os.Getenv("PHP_FPM_CONFIG_FILE")
-	if filePath == "" {
-		filePath = "/etc/dd-agent/conf.d/php_fpm.yaml"
-	}
-
-	currentHash, err := cfg.HashFileMd5(filePath)
-	if err != nil {
-		logger.Warnf("[php-fpm] Could not get initial hash for %s: %s", filePath, err)
-		currentHash = ""
-	}
-
-	logger.Infof("[php-fpm] Existing file hash %s: %s", filePath, currentHash)
-
-	stream := payload.Services.Observe()
-
-	for {
-		select {
-		case <-payload.QuitCh:
-			logger.Warn("[php-fpm] Stopping")
-			return
-
-		case <-stream.Changes():
-			stream.Next()
-
-			t := &Config{}
+func init() {
+	services.Register(&Backend{})
+}
 
-			services := stream.Value().(map[string]*consul.AgentService)
+// Backend monitors the local agent services for php-fpm services
+// and register them to the local datadog client
+type Backend struct{}
 
-			for _, service := range services {
-				if !cfg.ServiceEnabled("php-fpm", service.Tags) {
-					logger.Debugf("[php-fpm] Service %s does not contain 'dd-php-fpm' tag", service.Service)
-					continue
-				}
-				logger.Infof("[php-fpm] Service %s tags does contain 'dd-php-fpm'", service.Service)
+// Name ...
+func (b *Backend) Name() string { return "php-fpm" }
 
-				projectName := service.Service
+// TagSuffix ...
+func (b *Backend) TagSuffix() string { return "php-fpm" }
 
-				check := &ConfigITem{}
-				check.PingURL = fmt.Sprintf("http://%s:%d/php-fpm/%s/%s/%d/ping", service.Address, payload.ListenPort, projectName, service.Address, service.Port)
-				check.PingReply = "pong"
-				check.StatusURL = fmt.Sprintf("http://%s:%d/php-fpm/%s/%s/%d/status", service.Address, payload.ListenPort, projectName, service.Address, service.Port)
-				check.Tags = []string{
-					fmt.Sprintf("service:%s", projectName),
-				}
+// CheckName ...
+func (b *Backend) CheckName() string { return "php_fpm" }
 
-				t.Instances = append(t.Instances, check)
+// DefaultPath ...
+func (b *Backend) DefaultPath() string { return "/etc/dd-agent/conf.d/php_fpm.yaml" }
 
-				logger.Infof("[php-fpm] Service %s does match '-php-fpm' suffix", service.Service)
-			}
+// BuildInstance points the php-fpm check at our own fastcgi proxy, which connects
+// over TCP or, with the "socket" parameter, over a unix socket
+func (b *Backend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (services.Instance, error) {
+	projectName := service.Service
 
-			// Sort the services by name so we get consistent output across runs
-			sort.Sort(ServiceSorter(t.Instances))
+	pool, err := newPoolSettings(&payload.Settings.PHPFPM, service, params)
+	if err != nil {
+		return nil, err
+	}
 
-			data, err := yaml.Marshal(&t)
-			if err != nil {
-				logger.Fatalf("[php-fpm] Could not marshal yaml: %v", err)
-				break
-			}
+	check := &ConfigITem{}
+	check.PingReply = pool.pingReply
 
-			reloadRequired, newHash := cfg.WriteIfChange("php-fpm", filePath, data, currentHash)
-			if !reloadRequired {
-				currentHash = newHash
-				continue
-			}
+	if socket := params.String("socket", ""); socket != "" {
+		if !payload.Settings.PHPFPM.AllowsSocket(socket) {
+			return nil, fmt.Errorf("Socket %q is not allowed by php_fpm.sockets", socket)
+		}
 
-			payload.ReloadCh <- cfg.ReloadPayload{
-				Service: "php-fpm",
-				OldHash: currentHash,
-				NewHash: newHash,
-			}
+		check.PingURL = fmt.Sprintf("http://%s:%d/php-fpm-unix/%s/ping?socket=%s", service.Address, payload.ListenPort, projectName, url.QueryEscape(socket))
+		check.StatusURL = fmt.Sprintf("http://%s:%d/php-fpm-unix/%s/status?socket=%s", service.Address, payload.ListenPort, projectName, url.QueryEscape(socket))
+	} else {
+		check.PingURL = fmt.Sprintf("http://%s:%d/php-fpm/%s/%s/%d/ping", service.Address, payload.ListenPort, projectName, service.Address, service.Port)
+		check.StatusURL = fmt.Sprintf("http://%s:%d/php-fpm/%s/%s/%d/status", service.Address, payload.ListenPort, projectName, service.Address, service.Port)
+	}
 
-			currentHash = newHash
-		}
+	check.Tags = []string{
+		fmt.Sprintf("service:%s", projectName),
 	}
+
+	return check, nil
 }
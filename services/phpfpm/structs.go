@@ -1,18 +1,5 @@
 package phpfpm
 
-// ServiceSorter sorts planets by PingURL
-type ServiceSorter []*ConfigITem
-
-func (a ServiceSorter) Len() int           { return len(a) }
-func (a ServiceSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ServiceSorter) Less(i, j int) bool { return a[i].PingURL < a[j].PingURL }
-
-// Config ...
-type Config struct {
-	InitConfig []string      `yaml:"init_config,flow"`
-	Instances  []*ConfigITem `yaml:"instances"`
-}
-
 // ConfigITem ...
 type ConfigITem struct {
 	StatusURL string   `yaml:"status_url"`
@@ -20,3 +7,6 @@ type ConfigITem struct {
 	PingReply string   `yaml:"ping_reply"`
 	Tags      []string `yaml:"tags"`
 }
+
+// SortKey sorts instances by PingURL
+func (c *ConfigITem) SortKey() string { return c.PingURL }
@@ -2,93 +2,51 @@ package redisdb
 
 import (
 	"fmt"
-	"os"
-	"sort"
 
 	consul "github.com/hashicorp/consul/api"
 	cfg "github.com/seatgeek/datadog-service-helper/config"
-	"github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/seatgeek/datadog-service-helper/services"
 )
 
-var logger = logrus.New()
-
-// Observe changes in Consul catalog for redisdb
-func Observe(payload *cfg.ServicePayload) {
-	filePath := os.Getenv("REDISDB_CONFIG_FILE")
-	if filePath == "" {
-		filePath = "/etc/dd-agent/conf.d/redisdb.yaml"
-	}
-
-	currentHash, err := cfg.HashFileMd5(filePath)
-	if err != nil {
-		logger.Warnf("[redisdb] Could not get initial hash for %s: %s", filePath, err)
-		currentHash = ""
-	}
-
-	logger.Infof("[redisdb] Existing file hash %s: %s", filePath, currentHash)
-
-	stream := payload.Services.Observe()
-
-	for {
-		select {
-		case <-payload.QuitCh:
-			logger.Warn("[redisdb] stopping")
-			return
-
-		case <-stream.Changes():
-			stream.Next()
-
-			t := &Config{}
-
-			services := stream.Value().(map[string]*consul.AgentService)
-
-			for _, service := range services {
-				if !cfg.ServiceEnabled("redisdb", service.Tags) {
-					logger.Debugf("[redisdb] Service %s does not contain 'dd-redisdb' tag", service.Service)
-					continue
-				}
-				logger.Infof("[redisdb] Service %s tags does contain 'dd-redisdb'", service.Service)
-
-				check := &ConfigItem{
-					Host: service.Address,
-					Port: service.Port,
-					Tags: []string{
-						fmt.Sprintf("service:%s", service.Service),
-					},
-				}
+func init() {
+	services.Register(&Backend{})
+}
 
-				t.Instances = append(t.Instances, check)
-			}
+// Backend monitors Consul catalog for redisdb services
+type Backend struct{}
 
-			// Sort the services by name so we get consistent output across runs
-			sort.Sort(serviceSorter(t.Instances))
+// Name ...
+func (b *Backend) Name() string { return "redisdb" }
 
-			data, err := yaml.Marshal(&t)
-			if err != nil {
-				logger.Fatalf("[redisdb] could not marshal yaml: %v", err)
-			}
+// TagSuffix ...
+func (b *Backend) TagSuffix() string { return "redisdb" }
 
-			reloadRequired, newHash := cfg.WriteIfChange("redisdb", filePath, data, currentHash)
-			if !reloadRequired {
-				currentHash = newHash
-				continue
-			}
+// CheckName ...
+func (b *Backend) CheckName() string { return "redisdb" }
 
-			payload.ReloadCh <- cfg.ReloadPayload{
-				Service: "redisdb",
-				OldHash: currentHash,
-				NewHash: newHash,
-			}
+// DefaultPath ...
+func (b *Backend) DefaultPath() string { return "/etc/dd-agent/conf.d/redisdb.yaml" }
 
-			currentHash = newHash
-		}
+// BuildInstance supports the "db", "password" and "keys" (comma separated) parameters
+func (b *Backend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (services.Instance, error) {
+	db, err := params.Int("db", 0)
+	if err != nil {
+		return nil, err
+	}
+	if db < 0 {
+		return nil, fmt.Errorf("Invalid value for parameter db: %d must not be negative", db)
 	}
-}
 
-// serviceSorter sorts planets by ExpvarURL
-type serviceSorter []*ConfigItem
+	check := &ConfigItem{
+		Host:     service.Address,
+		Port:     service.Port,
+		DB:       db,
+		Password: params.String("password", ""),
+		Keys:     params.List("keys"),
+		Tags: []string{
+			fmt.Sprintf("service:%s", service.Service),
+		},
+	}
 
-func (a serviceSorter) Len() int           { return len(a) }
-func (a serviceSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a serviceSorter) Less(i, j int) bool { return a[i].Host < a[j].Host && a[i].Port < a[j].Port }
+	return check, nil
+}
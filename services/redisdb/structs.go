@@ -1,16 +1,18 @@
 package redisdb
 
-// See https://github.com/DataDog/integrations-core/tree/master/redisdb
+import "fmt"
 
-// Config ...
-type Config struct {
-	InitConfig []string      `yaml:"init_config,flow"`
-	Instances  []*ConfigItem `yaml:"instances"`
-}
+// See https://github.com/DataDog/integrations-core/tree/master/redisdb
 
 // ConfigItem ...
 type ConfigItem struct {
-	Host string   `yaml:"host"`
-	Port int      `yaml:"port"`
-	Tags []string `yaml:"tags"`
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	DB       int      `yaml:"db,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	Keys     []string `yaml:"keys,omitempty"`
+	Tags     []string `yaml:"tags"`
 }
+
+// SortKey sorts instances by Host + Port
+func (c *ConfigItem) SortKey() string { return fmt.Sprintf("%s:%05d", c.Host, c.Port) }
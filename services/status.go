@@ -0,0 +1,219 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seatgeek/datadog-service-helper/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BackendStatus describes what a backend did during its last sync
+type BackendStatus struct {
+	Name     string            `json:"name"`
+	Check    string            `json:"check"`
+	Tag      string            `json:"tag"`
+	Files    map[string]string `json:"files"`
+	LastSync time.Time         `json:"last_sync"`
+	// LastError is kept after a successful sync, so recent errors can still be
+	// shown, the sync recovered when LastSync is after LastErrorAt
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+	// Instances are the rendered instances, by Consul service ID
+	Instances map[string]interface{} `json:"instances"`
+	// Skipped are the reasons services were not monitored, by Consul service ID
+	Skipped map[string]string `json:"skipped"`
+}
+
+// redacted replaces secrets in the instances shown on /status and the dashboard
+const redacted = "[redacted]"
+
+// secretKey matches instance keys holding credentials, e.g. "password" or "api_key"
+var secretKey = regexp.MustCompile(`(?i)password|passwd|secret|token|api[_-]?key`)
+
+var (
+	statuses      = make(map[string]*BackendStatus)
+	statusesMutex sync.RWMutex
+)
+
+// Statuses returns the status of all running backends sorted by name
+func Statuses() []*BackendStatus {
+	statusesMutex.RLock()
+	defer statusesMutex.RUnlock()
+
+	list := make([]*BackendStatus, 0, len(statuses))
+	for _, status := range statuses {
+		copied := *status
+		list = append(list, &copied)
+	}
+
+	sort.Sort(statusSorter(list))
+	return list
+}
+
+// report records the result of a successful sync
+func (r *runner) report(entries []*entry, skipped map[string]string) {
+	status := &BackendStatus{
+		Name:      r.name,
+		Check:     r.backend.CheckName(),
+		Tag:       r.tag,
+		Files:     make(map[string]string),
+		LastSync:  time.Now(),
+		Instances: make(map[string]interface{}),
+		Skipped:   skipped,
+	}
+
+	statusesMutex.Lock()
+	defer statusesMutex.Unlock()
+
+	if previous, found := statuses[r.name]; found {
+		status.LastError = previous.LastError
+		status.LastErrorAt = previous.LastErrorAt
+	}
+
+	for filePath, hash := range r.files.hashes {
+		status.Files[filePath] = hash
+	}
+
+	for _, e := range entries {
+		status.Instances[e.service.ID] = redact(jsonValue(e.rendered), r.defaultKeys(e))
+	}
+
+	servicesDiscovered.Set(float64(len(entries)), r.name)
+	metrics.SetExpvarGauge(backendInstances, r.name, int64(len(entries)))
+	servicesSkipped.Set(float64(len(skipped)), r.name)
+
+	statuses[r.name] = status
+}
+
+// reportError records a failed sync, keeping the result of the last successful one
+func (r *runner) reportError(err error) {
+	statusesMutex.Lock()
+	defer statusesMutex.Unlock()
+
+	status, found := statuses[r.name]
+	if !found {
+		status = &BackendStatus{Name: r.name, Check: r.backend.CheckName(), Tag: r.tag}
+		statuses[r.name] = status
+	}
+
+	status.LastError = err.Error()
+	status.LastErrorAt = time.Now()
+	recentErrors.add(r.name, err)
+}
+
+// defaultKeys returns the keys an instance got from instance_defaults, those
+// often hold credentials shared by all services
+func (r *runner) defaultKeys(e *entry) map[string]bool {
+	own, _ := jsonValue(e.check).(map[string]interface{})
+
+	keys := make(map[string]bool)
+	for key := range r.settings.InstanceDefaults {
+		if _, found := own[key]; !found {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// redact hides the values of the keys in hide and of secret keys at any depth,
+// value is changed in place
+func redact(value interface{}, hide map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if hide[key] || secretKey.MatchString(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redact(item, nil)
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item, nil)
+		}
+	}
+
+	return value
+}
+
+// RedactTags hides the value of Consul tags holding secrets, e.g. "dd-redisdb:password=hunter2"
+func RedactTags(tags []string) []string {
+	list := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		i := strings.Index(tag, "=")
+		if i < 0 {
+			i = strings.Index(tag, ":")
+		}
+
+		if i >= 0 && secretKey.MatchString(tag[:i]) {
+			tag = tag[:i+1] + redacted
+		}
+
+		list = append(list, tag)
+	}
+
+	return list
+}
+
+// RedactMeta hides the values of Consul Meta keys holding secrets, e.g. "dd-redisdb-password"
+func RedactMeta(meta map[string]string) map[string]string {
+	redactedMeta := make(map[string]string, len(meta))
+	for key, value := range meta {
+		if secretKey.MatchString(key) {
+			value = redacted
+		}
+		redactedMeta[key] = value
+	}
+
+	return redactedMeta
+}
+
+// jsonValue converts an instance into something encoding/json can handle,
+// YAML maps use interface{} keys which it doesn't support
+func jsonValue(instance interface{}) interface{} {
+	data, err := yaml.Marshal(instance)
+	if err != nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+
+	return stringKeys(value)
+}
+
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = stringKeys(item)
+		}
+		return m
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+		return v
+
+	default:
+		return v
+	}
+}
+
+// statusSorter sorts backend statuses by name
+type statusSorter []*BackendStatus
+
+func (a statusSorter) Len() int           { return len(a) }
+func (a statusSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a statusSorter) Less(i, j int) bool { return a[i].Name < a[j].Name }
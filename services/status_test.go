@@ -0,0 +1,56 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactTags(t *testing.T) {
+	tags := []string{
+		"dd-redisdb",
+		"dd-redisdb:db=2",
+		"dd-redisdb:password=hunter2",
+		"dd-redisdb:Api-Key=abc=def",
+		"dd-tag-team:core",
+		"dd-tag-token:abc",
+		"urlprefix-/password-reset",
+	}
+
+	want := []string{
+		"dd-redisdb",
+		"dd-redisdb:db=2",
+		"dd-redisdb:password=[redacted]",
+		"dd-redisdb:Api-Key=[redacted]",
+		"dd-tag-team:core",
+		"dd-tag-token:[redacted]",
+		"urlprefix-/password-reset",
+	}
+
+	if got := RedactTags(tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactTags() = %v, want %v", got, want)
+	}
+}
+
+func TestRedactMeta(t *testing.T) {
+	meta := map[string]string{
+		"version":             "1.2.3",
+		"dd-redisdb-db":       "2",
+		"dd-redisdb-password": "hunter2",
+		"dd-go-expvar-SECRET": "abc",
+	}
+
+	want := map[string]string{
+		"version":             "1.2.3",
+		"dd-redisdb-db":       "2",
+		"dd-redisdb-password": "[redacted]",
+		"dd-go-expvar-SECRET": "[redacted]",
+	}
+
+	if got := RedactMeta(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactMeta() = %v, want %v", got, want)
+	}
+
+	if meta["dd-redisdb-password"] != "hunter2" {
+		t.Error("RedactMeta should not change the service Meta")
+	}
+}
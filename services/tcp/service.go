@@ -2,96 +2,56 @@ package tcp
 
 import (
 	"fmt"
-	"os"
-	"sort"
 
 	consul "github.com/hashicorp/consul/api"
 	cfg "github.com/seatgeek/datadog-service-helper/config"
-	"github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/seatgeek/datadog-service-helper/services"
 )
 
-var logger = logrus.New()
-
-// Observe changes in Consul catalog for TCPcheck
-func Observe(payload *cfg.ServicePayload) {
-	filePath := os.Getenv("TCP_CHECK_CONFIG_FILE")
-	if filePath == "" {
-		filePath = "/etc/dd-agent/conf.d/tcp_check.yaml"
-	}
-
-	currentHash, err := cfg.HashFileMd5(filePath)
-	if err != nil {
-		logger.Warnf("[TCPcheck] Could not get initial hash for %s: %s", filePath, err)
-		currentHash = ""
-	}
-
-	logger.Infof("[TCPcheck] Existing file hash %s: %s", filePath, currentHash)
-
-	stream := payload.Services.Observe()
-
-	for {
-		select {
-		case <-payload.QuitCh:
-			logger.Warn("[TCPcheck] stopping")
-			return
-
-		case <-stream.Changes():
-			stream.Next()
-
-			t := &Config{}
-
-			services := stream.Value().(map[string]*consul.AgentService)
-
-			for _, service := range services {
-				if !cfg.ServiceEnabled("tcp-check", service.Tags) {
-					logger.Debugf("[TCPcheck] Service %s does not contain 'dd-tcp-check' tag", service.Service)
-					continue
-				}
-				logger.Infof("[TCPcheck] Service %s tags does contain 'dd-tcp-check'", service.Service)
+func init() {
+	services.Register(&Backend{})
+}
 
-				check := &ConfigItem{
-					Name:                service.Service,
-					Host:                service.Address,
-					Port:                service.Port,
-					Timeout:             5,
-					CollectResponseTime: true,
-					Tags: []string{
-						fmt.Sprintf("service:%s", service.Service),
-					},
-				}
+// Backend monitors Consul catalog for TCP check services
+type Backend struct{}
 
-				t.Instances = append(t.Instances, check)
-			}
+// Name ...
+func (b *Backend) Name() string { return "tcp-check" }
 
-			// Sort the services by name so we get consistent output across runs
-			sort.Sort(serviceSorter(t.Instances))
+// TagSuffix ...
+func (b *Backend) TagSuffix() string { return "tcp-check" }
 
-			data, err := yaml.Marshal(&t)
-			if err != nil {
-				logger.Fatalf("[TCPcheck] could not marshal yaml: %v", err)
-			}
+// CheckName ...
+func (b *Backend) CheckName() string { return "tcp_check" }
 
-			reloadRequired, newHash := cfg.WriteIfChange("TCPcheck", filePath, data, currentHash)
-			if !reloadRequired {
-				currentHash = newHash
-				continue
-			}
+// DefaultPath ...
+func (b *Backend) DefaultPath() string { return "/etc/dd-agent/conf.d/tcp_check.yaml" }
 
-			payload.ReloadCh <- cfg.ReloadPayload{
-				Service: "TCPcheck",
-				OldHash: currentHash,
-				NewHash: newHash,
-			}
+// BuildInstance supports the "name", "timeout" and "collect_response_time" parameters
+func (b *Backend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (services.Instance, error) {
+	timeout, err := params.Int("timeout", 5)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("Invalid value for parameter timeout: %d must be positive", timeout)
+	}
 
-			currentHash = newHash
-		}
+	collectResponseTime, err := params.Bool("collect_response_time", true)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// serviceSorter sorts planets by Host + Port
-type serviceSorter []*ConfigItem
+	check := &ConfigItem{
+		Name:                params.String("name", service.Service),
+		Host:                service.Address,
+		Port:                service.Port,
+		Timeout:             timeout,
+		CollectResponseTime: collectResponseTime,
+		Tags: []string{
+			fmt.Sprintf("service:%s", service.Service),
+		},
+	}
 
-func (a serviceSorter) Len() int           { return len(a) }
-func (a serviceSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a serviceSorter) Less(i, j int) bool { return a[i].Port < a[j].Port }
+	return check, nil
+}
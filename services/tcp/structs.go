@@ -1,12 +1,8 @@
 package tcp
 
-// See https://github.com/DataDog/integrations-core/tree/master/redisdb
+import "fmt"
 
-// Config ...
-type Config struct {
-	InitConfig []string      `yaml:"init_config,flow"`
-	Instances  []*ConfigItem `yaml:"instances"`
-}
+// See https://github.com/DataDog/integrations-core/tree/master/tcp_check
 
 // ConfigItem ...
 type ConfigItem struct {
@@ -17,3 +13,6 @@ type ConfigItem struct {
 	CollectResponseTime bool     `yaml:"collect_response_time"`
 	Tags                []string `yaml:"tags"`
 }
+
+// SortKey sorts instances by Host + Port
+func (c *ConfigItem) SortKey() string { return fmt.Sprintf("%s:%05d", c.Host, c.Port) }
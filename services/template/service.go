@@ -0,0 +1,182 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	gotemplate "text/template"
+
+	consul "github.com/hashicorp/consul/api"
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/services"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Backend renders instances from an operator provided template, so new
+// DataDog checks can be monitored without writing Go code
+type Backend struct {
+	settings *cfg.TemplateSettings
+	render   renderer
+}
+
+// data is what the templates can access, e.g. "{{ .Address }}:{{ .Port }}"
+type data struct {
+	ID         string
+	Service    string
+	Address    string
+	Port       int
+	Tags       []string
+	Meta       map[string]string
+	Params     map[string]string
+	NodeName   string
+	ListenPort int
+}
+
+// renderer turns a compiled part of the instance template into its final value
+type renderer func(d *data) (interface{}, error)
+
+// New compiles all templates in the settings so mistakes are caught on startup
+func New(settings *cfg.TemplateSettings) (*Backend, error) {
+	render, err := compile(settings.Instance, "instance")
+	if err != nil {
+		return nil, fmt.Errorf("templates.%s: %s", settings.Name, err)
+	}
+
+	return &Backend{settings: settings, render: render}, nil
+}
+
+// Name ...
+func (b *Backend) Name() string { return b.settings.Name }
+
+// TagSuffix ...
+func (b *Backend) TagSuffix() string { return strings.TrimPrefix(b.settings.Tag, "dd-") }
+
+// CheckName defaults to the template name, with dashes replaced by underscores
+func (b *Backend) CheckName() string {
+	if b.settings.Check != "" {
+		return b.settings.Check
+	}
+
+	return strings.Replace(b.settings.Name, "-", "_", -1)
+}
+
+// DefaultPath ...
+func (b *Backend) DefaultPath() string { return b.settings.Path }
+
+// BuildInstance renders the instance template for the service
+func (b *Backend) BuildInstance(payload *cfg.ServicePayload, service *consul.AgentService, params *cfg.Params) (services.Instance, error) {
+	value, err := b.render(&data{
+		ID:         service.ID,
+		Service:    service.Service,
+		Address:    service.Address,
+		Port:       service.Port,
+		Tags:       service.Tags,
+		Meta:       service.Meta,
+		Params:     params.Values(),
+		NodeName:   payload.NodeName,
+		ListenPort: payload.ListenPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the rendered YAML doubles as sort key, so output is stable across runs
+	key, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigItem{value: value, key: string(key)}, nil
+}
+
+// compile walks the structured instance and parses every string as a template
+func compile(value interface{}, path string) (renderer, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return func(d *data) (interface{}, error) { return v, nil }, nil
+		}
+
+		t, err := gotemplate.New(path).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(d *data) (interface{}, error) {
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, d); err != nil {
+				return nil, err
+			}
+
+			return scalar(buf.String()), nil
+		}, nil
+
+	case []interface{}:
+		items := make([]renderer, len(v))
+		for i, item := range v {
+			r, err := compile(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = r
+		}
+
+		return func(d *data) (interface{}, error) {
+			list := make([]interface{}, 0, len(items))
+			for _, r := range items {
+				item, err := r(d)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, nil
+		}, nil
+
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		fields := make(map[string]renderer)
+		for k, item := range v {
+			key := fmt.Sprintf("%v", k)
+			r, err := compile(item, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			fields[key] = r
+		}
+		sort.Strings(keys)
+
+		return func(d *data) (interface{}, error) {
+			m := yaml.MapSlice{}
+			for _, key := range keys {
+				item, err := fields[key](d)
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, yaml.MapItem{Key: key, Value: item})
+			}
+			return m, nil
+		}, nil
+
+	default:
+		return func(d *data) (interface{}, error) { return v, nil }, nil
+	}
+}
+
+// scalar turns rendered numbers and booleans back into their YAML type,
+// so "{{ .Port }}" is written as 8080 rather than "8080"
+func scalar(text string) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(text), &value); err != nil {
+		return text
+	}
+
+	switch value.(type) {
+	case int, int64, uint64, float64, bool:
+		return value
+	default:
+		return text
+	}
+}
@@ -0,0 +1,13 @@
+package template
+
+// ConfigItem is a rendered instance, marshalled as-is into the dd-agent config
+type ConfigItem struct {
+	value interface{}
+	key   string
+}
+
+// SortKey sorts instances by their rendered YAML
+func (c *ConfigItem) SortKey() string { return c.key }
+
+// MarshalYAML ...
+func (c *ConfigItem) MarshalYAML() (interface{}, error) { return c.value, nil }
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/metrics"
+)
+
+var validationErrors = metrics.NewExpvarMap("config_validation_errors", metrics.Rate)
+
+// stagedFile is the new content of a file, not written yet
+type stagedFile struct {
+	path string
+	data []byte
+	hash string
+	// stagedPath is the copy the validation command checks, outside the agent's directory
+	stagedPath string
+}
+
+// validate runs the validation command on copies of the staged files in a scratch
+// directory, so the agent's directory only ever holds files that passed it
+func (r *runner) validate(staged []*stagedFile) error {
+	command := r.payload.Settings.Validation.Command
+	if len(command) == 0 || len(staged) == 0 {
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "datadog-service-helper-")
+	if err != nil {
+		return fmt.Errorf("Could not create validation directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range staged {
+		if file.stagedPath, err = cfg.WriteStaged(dir, file.path, file.data); err != nil {
+			return fmt.Errorf("Could not stage %s for validation: %s", file.path, err)
+		}
+	}
+
+	err = r.runValidation(command, dir, staged)
+	if err == nil {
+		for _, file := range staged {
+			delete(r.files.rejected, file.path)
+		}
+		return nil
+	}
+
+	validationErrors.Add(r.name, 1)
+
+	hide := make(map[string]bool)
+	for key := range r.settings.InstanceDefaults {
+		hide[key] = true
+	}
+
+	for _, file := range staged {
+		current, _ := ioutil.ReadFile(file.path)
+		rejected, _ := ioutil.ReadFile(file.stagedPath)
+		logger.Errorf("[%s] Rejected changes to %s:\n%s", r.name, file.path, lineDiff(current, rejected, hide))
+
+		r.files.rejected[file.path] = file.hash
+	}
+
+	return fmt.Errorf("Validation failed, kept the previous files: %s", err)
+}
+
+// runValidation runs the command once from dir, or once per file if it references "{path}"
+func (r *runner) runValidation(command []string, dir string, staged []*stagedFile) error {
+	perFile := false
+	for _, arg := range command {
+		perFile = perFile || strings.Contains(arg, "{path}")
+	}
+
+	if !perFile {
+		return r.runCommand(command, dir)
+	}
+
+	for _, file := range staged {
+		args := make([]string, len(command))
+		for i, arg := range command {
+			args[i] = strings.Replace(arg, "{path}", file.stagedPath, -1)
+		}
+
+		if err := r.runCommand(args, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) runCommand(command []string, dir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.payload.Settings.Validation.Timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out", strings.Join(command, " "))
+		}
+		return fmt.Errorf("%s failed: %s (output: %s)", strings.Join(command, " "), err, strings.TrimSpace(output.String()))
+	}
+
+	logger.Debugf("[%s] Validation passed: %s", r.name, strings.TrimSpace(output.String()))
+	return nil
+}
+
+// lineDiff is a minimal diff of two files, listing removed and added lines,
+// with the values of secrets and of the keys in hide redacted
+func lineDiff(previous, current []byte, hide map[string]bool) string {
+	oldLines := strings.Split(string(previous), "\n")
+	newLines := strings.Split(string(current), "\n")
+
+	count := func(lines []string) map[string]int {
+		m := make(map[string]int)
+		for _, line := range lines {
+			m[line]++
+		}
+		return m
+	}
+	oldCount, newCount := count(oldLines), count(newLines)
+
+	var diff bytes.Buffer
+	for _, line := range oldLines {
+		if newCount[line] > 0 {
+			newCount[line]--
+			continue
+		}
+		diff.WriteString("- " + redactLine(line, hide) + "\n")
+	}
+
+	for _, line := range newLines {
+		if oldCount[line] > 0 {
+			oldCount[line]--
+			continue
+		}
+		diff.WriteString("+ " + redactLine(line, hide) + "\n")
+	}
+
+	return diff.String()
+}
+
+// redactLine hides the value of a YAML line holding a secret, e.g. "  password: hunter2"
+// or "  - dd-redisdb:password=hunter2", using the rules of the status pages
+func redactLine(line string, hide map[string]bool) string {
+	i := strings.IndexAny(line, ":=")
+	if i < 0 {
+		if secretKey.MatchString(line) {
+			return redacted
+		}
+		return line
+	}
+
+	key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[:i]), "- "))
+	if !hide[key] && !secretKey.MatchString(line) {
+		return line
+	}
+
+	return line[:i+1] + " " + redacted
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		command []string
+		content string
+		valid   bool
+	}{
+		{"per file", []string{"sh", "-c", `grep -q good "$0"`, "{path}"}, "instances: [good]\n", true},
+		{"per file rejected", []string{"sh", "-c", `grep -q good "$0"`, "{path}"}, "instances: [bad]\n", false},
+		{"scratch directory", []string{"sh", "-c", "grep -q good tcp_check.d/service-helper.yaml"}, "instances: [good]\n", true},
+		{"scratch directory rejected", []string{"sh", "-c", "grep -q good tcp_check.d/service-helper.yaml"}, "instances: [bad]\n", false},
+	}
+
+	for _, test := range tests {
+		settings := cfg.DefaultSettings()
+		settings.Agent = cfg.AgentSettings{Layout: cfg.LayoutV6, ConfDir: dir}
+		settings.Validation.Command = test.command
+
+		r := &runner{
+			name:     "test",
+			payload:  &cfg.ServicePayload{Settings: settings},
+			settings: &cfg.BackendSettings{},
+			files:    newFileSet(&testBackend{check: "tcp_check"}, &cfg.BackendSettings{}, settings.Agent),
+		}
+
+		file := &stagedFile{
+			path: r.files.filePath,
+			data: []byte(test.content),
+			hash: cfg.ContentHash([]byte(test.content)),
+		}
+
+		err := r.validate([]*stagedFile{file})
+		if test.valid != (err == nil) {
+			t.Errorf("%s: validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+
+		if rejected := r.files.rejected[file.path] == file.hash; rejected == test.valid {
+			t.Errorf("%s: rejected = %v, want %v", test.name, rejected, !test.valid)
+		}
+
+		// only sync writes the file, once validation passed
+		if _, err := os.Stat(filepath.Join(dir, "tcp_check.d")); !os.IsNotExist(err) {
+			t.Errorf("%s: validate should not touch the conf.d directory (%v)", test.name, err)
+		}
+	}
+}
+
+func TestRedactLine(t *testing.T) {
+	hide := map[string]bool{"auth": true}
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"  port: 6379", "  port: 6379"},
+		{"  password: hunter2", "  password: [redacted]"},
+		{"- api_key: abc", "- api_key: [redacted]"},
+		{"  - dd-redisdb:password=hunter2", "  - dd-redisdb: [redacted]"},
+		{"  auth: hunter2", "  auth: [redacted]"},
+		{"  - service:app", "  - service:app"},
+		{"  - secret-value", "[redacted]"},
+	}
+
+	for _, test := range tests {
+		if got := redactLine(test.line, hide); got != test.want {
+			t.Errorf("redactLine(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
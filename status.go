@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	cfg "github.com/seatgeek/datadog-service-helper/config"
+	"github.com/seatgeek/datadog-service-helper/services"
+)
+
+// serviceStatus is a Consul service and what each backend did with it
+type serviceStatus struct {
+	ID      string                 `json:"id"`
+	Service string                 `json:"service"`
+	Address string                 `json:"address"`
+	Port    int                    `json:"port"`
+	Tags    []string               `json:"tags"`
+	Meta    map[string]string      `json:"meta"`
+	Health  string                 `json:"health"`
+	Checks  map[string]interface{} `json:"checks"`
+	Skipped map[string]string      `json:"skipped"`
+}
+
+// status is the document served on /status
+type status struct {
+	NodeName string                    `json:"node_name"`
+	Consul   consulStatus              `json:"consul"`
+	Services []*serviceStatus          `json:"services"`
+	Backends []*services.BackendStatus `json:"backends"`
+}
+
+// buildStatus combines the current Consul snapshot with the result of the last sync of every backend
+func buildStatus(nodeName string) *status {
+	backends := services.Statuses()
+	current := consulServices.Value().(map[string]*cfg.Service)
+
+	list := make([]*serviceStatus, 0, len(current))
+	for id, service := range current {
+		s := &serviceStatus{
+			ID:      id,
+			Service: service.Service,
+			Address: service.Address,
+			Port:    service.Port,
+			Tags:    services.RedactTags(service.Tags),
+			Meta:    services.RedactMeta(service.Meta),
+			Health:  service.Health,
+			Checks:  make(map[string]interface{}),
+			Skipped: make(map[string]string),
+		}
+
+		for _, backend := range backends {
+			if instance, found := backend.Instances[id]; found {
+				s.Checks[backend.Name] = instance
+			} else if reason, found := backend.Skipped[id]; found {
+				s.Skipped[backend.Name] = reason
+			}
+		}
+
+		list = append(list, s)
+	}
+
+	sort.Sort(serviceStatusSorter(list))
+
+	return &status{
+		NodeName: nodeName,
+		Consul:   consulTracker.status(),
+		Services: list,
+		Backends: backends,
+	}
+}
+
+// statusHandler serves the status of all discovered services and generated checks as JSON
+func statusHandler(nodeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := json.MarshalIndent(buildStatus(nodeName), "", "  ")
+		if err != nil {
+			message := fmt.Sprintf("[statusHandler] Could not marshal JSON: %s", err)
+			logger.Error(message)
+			http.Error(w, message, 500)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
+
+// serviceStatusSorter sorts service statuses by ID
+type serviceStatusSorter []*serviceStatus
+
+func (a serviceStatusSorter) Len() int           { return len(a) }
+func (a serviceStatusSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a serviceStatusSorter) Less(i, j int) bool { return a[i].ID < a[j].ID }